@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/urfave/cli/v2"
+
+	"github.com/protolambda/consensus-actor/fun"
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+var (
+	EraEraFlag = &cli.PathFlag{
+		Name:      "era",
+		Usage:     "Path to era store dir",
+		TakesFile: true,
+		Required:  true,
+	}
+	EraIndexFlag = &cli.PathFlag{
+		Name:      "era-index",
+		Usage:     "Path to the persisted era store index, to skip reading unchanged era files on startup. Empty to disable.",
+		TakesFile: true,
+		Value:     "era_index",
+	}
+	EraWorkersFlag = &cli.IntFlag{
+		Name:  "workers",
+		Usage: "number of workers to used to process in parallel",
+		Value: 8,
+	}
+)
+
+var EraCmd = &cli.Command{
+	Name:        "era",
+	Usage:       "Era file archive tools.",
+	Description: "Era file archive tools.",
+	Subcommands: []*cli.Command{
+		EraVerifyCmd,
+	},
+}
+
+var EraVerifyCmd = &cli.Command{
+	Name:        "verify",
+	Usage:       "Verify integrity of era files.",
+	Description: "Verify block roots, block slots, historical accumulators and file names of all era files in the era store.",
+	Action:      EraVerify,
+	Flags: []cli.Flag{
+		LogLevelFlag,
+		LogFormatFlag,
+		LogColorFlag,
+		EraEraFlag,
+		EraWorkersFlag,
+		NetworkFlag,
+		SpecFlag,
+	},
+}
+
+func EraVerify(ctx *cli.Context) error {
+	log, err := SetupLogger(ctx)
+	if err != nil {
+		return err
+	}
+	spec, _, err := SetupSpec(ctx)
+	if err != nil {
+		return err
+	}
+	workers := ctx.Int(EraWorkersFlag.Name)
+	if workers <= 0 || workers > 128 {
+		return fmt.Errorf("invalid workers count: %d", workers)
+	}
+
+	es := era.NewStore()
+	if err := es.Load(ctx.Path(EraEraFlag.Name)); err != nil {
+		return fmt.Errorf("failed to index era store: %w", err)
+	}
+	defer es.Close()
+
+	ranges := es.Coverage()
+	coverage := make([]string, len(ranges))
+	for i, r := range ranges {
+		coverage[i] = r.String()
+	}
+	fmt.Printf("coverage: %s\n", strings.Join(coverage, ", "))
+	for _, d := range es.Diagnostics() {
+		fmt.Printf("WARN  %s\n", d)
+	}
+
+	reports, err := fun.VerifyEras(ctx.Context, log, spec, es, workers)
+	if err != nil {
+		return fmt.Errorf("failed to verify era store: %w", err)
+	}
+
+	failed := 0
+	for _, r := range reports {
+		if r.OK() {
+			fmt.Printf("OK    era %05d %s\n", r.Era, r.Path)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  era %05d %s\n", r.Era, r.Path)
+		for _, iss := range r.Issues {
+			fmt.Printf("      %s\n", iss)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d era files failed verification", failed, len(reports))
+	}
+	return nil
+}
+
+// logEraDiagnostics warns about problems found while loading the era store.
+func logEraDiagnostics(log log.Logger, es *era.Store) {
+	for _, d := range es.Diagnostics() {
+		log.Warn("era store problem", "kind", d.Kind, "era", d.Slot/era.SlotsPerEra, "path", d.Path, "msg", d.Msg)
+	}
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/protolambda/consensus-actor/fun"
+	"github.com/protolambda/consensus-actor/fun/beaconapi"
+)
+
+var (
+	LivePerfFlag = &cli.PathFlag{
+		Name:      "perf",
+		Usage:     "Path to validator perf database to output to",
+		TakesFile: true,
+		Value:     "perf_db",
+	}
+	LiveTilesFlag = &cli.PathFlag{
+		Name:  "tiles",
+		Usage: "path to tiles db to write tile data to",
+		Value: "tiles_db",
+	}
+	LiveRetryFlag = &cli.DurationFlag{
+		Name:  "retry",
+		Usage: "Duration to wait before reconnecting to the beacon node event stream after a failure",
+		Value: 10 * time.Second,
+	}
+)
+
+var LiveCmd = &cli.Command{
+	Name:  "live",
+	Usage: "Track the head of a beacon node, and keep validator performance data and tiles up to date with it.",
+	Description: "Follow the head, chain reorg and finalized checkpoint events of the beacon node. " +
+		"Data of unfinalized epochs is marked as provisional, and rolled back and recomputed on a reorg. " +
+		"The validator performance data must be close to the finalized epoch, use the sync command to catch up from era files first.",
+	Action: Live,
+	Flags: []cli.Flag{
+		LogLevelFlag,
+		LogFormatFlag,
+		LogColorFlag,
+		LivePerfFlag,
+		LiveTilesFlag,
+		LiveRetryFlag,
+		TilesCustomOrderFlag,
+		BeaconAPIFlag,
+		NetworkFlag,
+		SpecFlag,
+	},
+}
+
+func Live(ctx *cli.Context) error {
+	log, err := SetupLogger(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := ctx.String(BeaconAPIFlag.Name)
+	if endpoint == "" {
+		return fmt.Errorf("need a beacon API endpoint to track the head of")
+	}
+	retry := ctx.Duration(LiveRetryFlag.Name)
+	if retry <= 0 {
+		return fmt.Errorf("invalid retry duration: %s", retry)
+	}
+
+	spec, network, err := SetupSpec(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info("loaded spec", "network", network)
+	if err := SetupOrderings(ctx, log); err != nil {
+		return err
+	}
+
+	perfDB, err := fun.OpenDB(ctx.Path(LivePerfFlag.Name), false, 100, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open perf db: %w", err)
+	}
+	defer perfDB.Close()
+	tilesDB, err := fun.OpenDB(ctx.Path(LiveTilesFlag.Name), false, 100, 100)
+	if err != nil {
+		return fmt.Errorf("failed to open tiles db: %w", err)
+	}
+	defer tilesDB.Close()
+
+	sigCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info("tracking beacon node", "endpoint", endpoint)
+	return fun.Live(sigCtx, log, perfDB, tilesDB, spec, beaconapi.NewClient(endpoint), retry)
+}
@@ -4,10 +4,10 @@ import (
 	"fmt"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
-	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/urfave/cli/v2"
 
 	"github.com/protolambda/consensus-actor/fun"
+	"github.com/protolambda/consensus-actor/fun/beaconapi"
 	"github.com/protolambda/consensus-actor/fun/era"
 )
 
@@ -21,9 +21,8 @@ var (
 	}
 	PerfEraFlag = &cli.PathFlag{
 		Name:      "era",
-		Usage:     "Path to era store dir",
+		Usage:     "Path to era store dir. Required.",
 		TakesFile: true,
-		Required:  true,
 	}
 	PerfStartEpochFlag = &cli.Uint64Flag{
 		Name:  "start-epoch",
@@ -35,12 +34,25 @@ var (
 		Usage: "End epoch (exclusive) of validator performance data to update",
 		Value: ^uint64(0),
 	}
+	BeaconAPIFlag = &cli.StringFlag{
+		Name:    "beacon-api",
+		Usage:   "Beacon node API endpoint, to compute the finalized epochs that are not archived in era files yet. Optional.",
+		EnvVars: []string{"BEACON_API"},
+	}
 	PerfWorkersFlag = &cli.IntFlag{
 		Name:  "workers",
 		Usage: "number of workers to used to process in parallel",
 		Value: 8,
 	}
-	// TODO spec flag
+	ResetFromEpochFlag = &cli.Uint64Flag{
+		Name:     "from-epoch",
+		Usage:    "First epoch (inclusive) of data to remove, all later data is removed too",
+		Required: true,
+	}
+	ResetDryRunFlag = &cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Only print what would be removed",
+	}
 )
 
 var PerfCmd = &cli.Command{
@@ -54,9 +66,53 @@ var PerfCmd = &cli.Command{
 		LogColorFlag,
 		PerfPerfFlag,
 		PerfEraFlag,
+		EraIndexFlag,
 		PerfStartEpochFlag,
 		PerfEndEpochFlag,
 		PerfWorkersFlag,
+		BeaconAPIFlag,
+		NetworkFlag,
+		SpecFlag,
+	},
+	Subcommands: []*cli.Command{
+		PerfResetCmd,
+		PerfCheckCmd,
+	},
+}
+
+var PerfResetCmd = &cli.Command{
+	Name:        "reset",
+	Usage:       "Remove validator performance data from an epoch onwards.",
+	Description: "Remove validator performance data of the given epoch and later, to recompute it. The tiles of the removed epochs have to be reset separately.",
+	Action:      PerfReset,
+	Flags: []cli.Flag{
+		LogLevelFlag,
+		LogFormatFlag,
+		LogColorFlag,
+		PerfPerfFlag,
+		ResetFromEpochFlag,
+		ResetDryRunFlag,
+		NetworkFlag,
+		SpecFlag,
+	},
+}
+
+var PerfCheckCmd = &cli.Command{
+	Name:  "check",
+	Usage: "Cross-validate the validator performance computation against on-chain participation flags.",
+	Description: "For every era boundary epoch since Altair in the epoch range, compute the validator performance of the last epoch of the era, " +
+		"and compare it to the participation flags of the era state. Mismatching validators are reported.",
+	Action: PerfCheck,
+	Flags: []cli.Flag{
+		LogLevelFlag,
+		LogFormatFlag,
+		LogColorFlag,
+		PerfEraFlag,
+		EraIndexFlag,
+		PerfStartEpochFlag,
+		PerfEndEpochFlag,
+		NetworkFlag,
+		SpecFlag,
 	},
 }
 
@@ -65,11 +121,14 @@ func Perf(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if ctx.Path(PerfEraFlag.Name) == "" {
+		return fmt.Errorf("need era store dir to compute validator performance from")
+	}
 	startEpoch := common.Epoch(ctx.Uint64(PerfStartEpochFlag.Name))
 	endEpoch := common.Epoch(ctx.Uint64(PerfEndEpochFlag.Name))
 
 	workers := ctx.Int(PerfWorkersFlag.Name)
-	if workers < 0 || workers > 128 {
+	if workers <= 0 || workers > 128 {
 		return fmt.Errorf("invalid workers count: %d", workers)
 	}
 
@@ -80,31 +139,78 @@ func Perf(ctx *cli.Context) error {
 	defer perfDB.Close()
 
 	es := era.NewStore()
+	es.IndexPath = ctx.Path(EraIndexFlag.Name)
 	if err := es.Load(ctx.Path(PerfEraFlag.Name)); err != nil {
 		return fmt.Errorf("failed to index era store: %w", err)
 	}
-	spec := configs.Mainnet
-	spec.BELLATRIX_FORK_EPOCH = 144896
-	spec.CAPELLA_FORK_EPOCH = 194048
-
-	minSlot, maxSlot := es.Bounds()
-	minEpoch, maxEpoch := spec.SlotToEpoch(minSlot), spec.SlotToEpoch(maxSlot)
-	if minEpoch >= maxEpoch {
-		return fmt.Errorf("no era data")
+	defer es.Close()
+	logEraDiagnostics(log, es)
+	spec, network, err := SetupSpec(ctx)
+	if err != nil {
+		return err
 	}
+	log.Info("loaded spec", "network", network)
 
-	epochsPerEra := spec.SlotToEpoch(era.SlotsPerEra)
-	if minEpoch > startEpoch+epochsPerEra-2 {
-		startEpoch = minEpoch - epochsPerEra + 2
-		log.Warn("adjusting lower bound", "start_epoch", startEpoch, "min_era_epoch", minEpoch)
-	}
-	if maxEpoch < endEpoch {
-		endEpoch = maxEpoch
-		log.Warn("adjusting upper bound", "end_epoch", startEpoch, "max_era_epoch", maxEpoch)
+	startEpoch, endEpoch, err = fun.PerfRange(log, spec, es, startEpoch, endEpoch)
+	if err != nil {
+		return err
 	}
 
 	if err := fun.UpdatePerf(ctx.Context, log, perfDB, spec, es, startEpoch, endEpoch, workers); err != nil {
 		return fmt.Errorf("failed to update validator performance data: %w", err)
 	}
+	if endpoint := ctx.String(BeaconAPIFlag.Name); endpoint != "" {
+		if _, _, err := fun.UpdatePerfTail(ctx.Context, log, perfDB, spec, beaconapi.NewClient(endpoint)); err != nil {
+			return fmt.Errorf("failed to update validator performance data from beacon API: %w", err)
+		}
+	}
 	return nil
 }
+
+func PerfReset(ctx *cli.Context) error {
+	log, err := SetupLogger(ctx)
+	if err != nil {
+		return err
+	}
+	spec, network, err := SetupSpec(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info("loaded spec", "network", network)
+
+	dryRun := ctx.Bool(ResetDryRunFlag.Name)
+	perfDB, err := fun.OpenDB(ctx.Path(PerfPerfFlag.Name), dryRun, 100, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open perf db: %w", err)
+	}
+	defer perfDB.Close()
+
+	return fun.ResetPerf(log, perfDB, spec, common.Epoch(ctx.Uint64(ResetFromEpochFlag.Name)), dryRun)
+}
+
+func PerfCheck(ctx *cli.Context) error {
+	log, err := SetupLogger(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.Path(PerfEraFlag.Name) == "" {
+		return fmt.Errorf("need era store dir to check validator performance with")
+	}
+	spec, network, err := SetupSpec(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info("loaded spec", "network", network)
+
+	es := era.NewStore()
+	es.IndexPath = ctx.Path(EraIndexFlag.Name)
+	if err := es.Load(ctx.Path(PerfEraFlag.Name)); err != nil {
+		return fmt.Errorf("failed to index era store: %w", err)
+	}
+	defer es.Close()
+	logEraDiagnostics(log, es)
+
+	startEpoch := common.Epoch(ctx.Uint64(PerfStartEpochFlag.Name))
+	endEpoch := common.Epoch(ctx.Uint64(PerfEndEpochFlag.Name))
+	return fun.CheckPerf(ctx.Context, log, spec, es, startEpoch, endEpoch)
+}
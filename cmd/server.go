@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/urfave/cli/v2"
 
@@ -37,6 +38,8 @@ var ServerCmd = &cli.Command{
 		LogColorFlag,
 		ServerListenAddrFlag,
 		ServerTilesFlag,
+		NetworkFlag,
+		SpecFlag,
 	},
 }
 
@@ -46,6 +49,11 @@ func Server(ctx *cli.Context) error {
 		return err
 	}
 
+	_, network, err := SetupSpec(ctx)
+	if err != nil {
+		return err
+	}
+
 	listenAddr := ctx.String(ServerListenAddrFlag.Name)
 	publicEndpoint := ctx.String(ServerPublicFlag.Name)
 
@@ -60,9 +68,10 @@ func Server(ctx *cli.Context) error {
 	imgHandler := &fun.ImageHandler{Log: log, TilesDB: tilesDB}
 
 	srv := fun.StartHttpServer(log, listenAddr, &fun.IndexData{
-		Title: "Consensus.actor | mainnet",
+		Title: "Consensus.actor | " + network,
 		API:   publicEndpoint,
-	}, imgHandler.HandleImgRequest)
+	}, imgHandler.HandleImgRequest,
+		http.HandlerFunc(imgHandler.HandleFinalized), http.HandlerFunc(imgHandler.HandleIncidents))
 
 	<-ctx.Done()
 
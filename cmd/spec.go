@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/urfave/cli/v2"
+
+	"github.com/protolambda/consensus-actor/fun"
+)
+
+var (
+	NetworkFlag = &cli.StringFlag{
+		Name:    "network",
+		Usage:   "Name of the network preset to use. Supported networks: " + strings.Join(fun.NetworkNames(), ", "),
+		Value:   "mainnet",
+		EnvVars: []string{"NETWORK"},
+	}
+	SpecFlag = &cli.PathFlag{
+		Name:      "spec",
+		Usage:     "Path to a JSON or YAML spec config file, overrides the network preset",
+		TakesFile: true,
+		EnvVars:   []string{"SPEC"},
+	}
+)
+
+// SetupSpec loads the spec from the spec file if specified, or the network preset otherwise.
+// The name of the network is returned along with the spec.
+func SetupSpec(ctx *cli.Context) (spec *common.Spec, name string, err error) {
+	if p := ctx.Path(SpecFlag.Name); p != "" {
+		spec, err = fun.LoadSpec(p)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load spec: %w", err)
+		}
+		name = spec.CONFIG_NAME
+		if name == "" {
+			name = "custom"
+		}
+		return spec, name, nil
+	}
+	name = ctx.String(NetworkFlag.Name)
+	spec, err = fun.NetworkSpec(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return spec, name, nil
+}
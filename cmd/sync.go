@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/protolambda/consensus-actor/fun"
+	"github.com/protolambda/consensus-actor/fun/beaconapi"
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+var (
+	SyncEraFlag = &cli.PathFlag{
+		Name:      "era",
+		Usage:     "Path to era store dir to watch",
+		TakesFile: true,
+		Required:  true,
+	}
+	SyncPerfFlag = &cli.PathFlag{
+		Name:      "perf",
+		Usage:     "Path to validator perf database to output to",
+		TakesFile: true,
+		Value:     "perf_db",
+	}
+	SyncTilesFlag = &cli.PathFlag{
+		Name:  "tiles",
+		Usage: "path to tiles db to write tile data to",
+		Value: "tiles_db",
+	}
+	SyncIntervalFlag = &cli.DurationFlag{
+		Name:  "interval",
+		Usage: "Interval to check the era store dir for new era files",
+		Value: time.Minute,
+	}
+	SyncWorkersFlag = &cli.IntFlag{
+		Name:  "workers",
+		Usage: "number of workers to used to process in parallel",
+		Value: 8,
+	}
+)
+
+var SyncCmd = &cli.Command{
+	Name:        "sync",
+	Usage:       "Keep validator performance data and tiles up to date with the era store.",
+	Description: "Watch the era store dir for new era files, and incrementally update the validator performance data and tiles.",
+	Action:      Sync,
+	Flags: []cli.Flag{
+		LogLevelFlag,
+		LogFormatFlag,
+		LogColorFlag,
+		SyncEraFlag,
+		EraIndexFlag,
+		SyncPerfFlag,
+		SyncTilesFlag,
+		SyncIntervalFlag,
+		SyncWorkersFlag,
+		TilesCustomOrderFlag,
+		BeaconAPIFlag,
+		NetworkFlag,
+		SpecFlag,
+	},
+}
+
+func Sync(ctx *cli.Context) error {
+	log, err := SetupLogger(ctx)
+	if err != nil {
+		return err
+	}
+	workers := ctx.Int(SyncWorkersFlag.Name)
+	if workers <= 0 || workers > 128 {
+		return fmt.Errorf("invalid workers count: %d", workers)
+	}
+	interval := ctx.Duration(SyncIntervalFlag.Name)
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval: %s", interval)
+	}
+
+	spec, network, err := SetupSpec(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info("loaded spec", "network", network)
+	if err := SetupOrderings(ctx, log); err != nil {
+		return err
+	}
+
+	perfDB, err := fun.OpenDB(ctx.Path(SyncPerfFlag.Name), false, 100, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open perf db: %w", err)
+	}
+	defer perfDB.Close()
+	tilesDB, err := fun.OpenDB(ctx.Path(SyncTilesFlag.Name), false, 100, 100)
+	if err != nil {
+		return fmt.Errorf("failed to open tiles db: %w", err)
+	}
+	defer tilesDB.Close()
+
+	es := era.NewStore()
+	es.IndexPath = ctx.Path(EraIndexFlag.Name)
+	if err := es.Load(ctx.Path(SyncEraFlag.Name)); err != nil {
+		return fmt.Errorf("failed to index era store: %w", err)
+	}
+	defer es.Close()
+
+	sigCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info("syncing", "era", ctx.Path(SyncEraFlag.Name), "interval", interval)
+	var api *beaconapi.Client
+	if endpoint := ctx.String(BeaconAPIFlag.Name); endpoint != "" {
+		api = beaconapi.NewClient(endpoint)
+	}
+	return fun.Sync(sigCtx, log, perfDB, tilesDB, spec, es, api, workers, interval)
+}
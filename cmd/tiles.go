@@ -2,7 +2,10 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/urfave/cli/v2"
 
@@ -32,8 +35,34 @@ var (
 		Usage: "End epoch (exclusive) of tiles to update",
 		Value: ^uint64(0),
 	}
+	TilesTypeFlag = &cli.UintFlag{
+		Name:  "type",
+		Usage: "Tile type to reset, one of: " + tileTypesUsage(),
+		Value: 0,
+	}
+	TilesCustomOrderFlag = &cli.PathFlag{
+		Name: "custom-order",
+		Usage: "Path to a file with lists of validator indices, one list per line, separated by commas or spaces, " +
+			"to order the validators of the custom order tiles with. Empty to not compute custom order tiles.",
+		TakesFile: true,
+	}
 )
 
+// tileTypesUsage lists the tile types, with the names of the tile types.
+func tileTypesUsage() string {
+	types := fun.TileTypes()
+	keys := make([]int, 0, len(types))
+	for t := range types {
+		keys = append(keys, int(t))
+	}
+	sort.Ints(keys)
+	out := make([]string, 0, len(keys))
+	for _, t := range keys {
+		out = append(out, fmt.Sprintf("%d (%s)", t, types[uint8(t)]))
+	}
+	return strings.Join(out, ", ")
+}
+
 var TilesCmd = &cli.Command{
 	Name:        "tiles",
 	Usage:       "Compute tiles for range of epochs.",
@@ -47,6 +76,29 @@ var TilesCmd = &cli.Command{
 		TilesTilesFlag,
 		TilesStartEpochFlag,
 		TilesEndEpochFlag,
+		TilesCustomOrderFlag,
+	},
+	Subcommands: []*cli.Command{
+		TilesResetCmd,
+	},
+}
+
+var TilesResetCmd = &cli.Command{
+	Name:  "reset",
+	Usage: "Remove tiles from an epoch onwards.",
+	Description: "Remove the tiles of the given type that cover the given epoch and later, at every zoom level, to recompute them. " +
+		"Tiles cover multiple epochs, the first removed tile may start before the given epoch.",
+	Action: TilesReset,
+	Flags: []cli.Flag{
+		LogLevelFlag,
+		LogFormatFlag,
+		LogColorFlag,
+		TilesTilesFlag,
+		TilesTypeFlag,
+		ResetFromEpochFlag,
+		ResetDryRunFlag,
+		NetworkFlag,
+		SpecFlag,
 	},
 }
 
@@ -55,6 +107,9 @@ func Tiles(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if err := SetupOrderings(ctx, log); err != nil {
+		return err
+	}
 	startEpoch := common.Epoch(ctx.Uint64(TilesStartEpochFlag.Name))
 	endEpoch := common.Epoch(ctx.Uint64(TilesEndEpochFlag.Name))
 	perfDB, err := fun.OpenDB(ctx.Path(TilesPerfFlag.Name), true, 100, 0)
@@ -69,3 +124,43 @@ func Tiles(ctx *cli.Context) error {
 	defer tilesDB.Close()
 	return fun.UpdateTiles(log, tilesDB, perfDB, startEpoch, endEpoch)
 }
+
+func TilesReset(ctx *cli.Context) error {
+	log, err := SetupLogger(ctx)
+	if err != nil {
+		return err
+	}
+	tileType := ctx.Uint(TilesTypeFlag.Name)
+	if _, ok := fun.TileTypes()[uint8(tileType)]; !ok || tileType > 0xff {
+		return fmt.Errorf("invalid tile type %d, expected one of: %s", tileType, tileTypesUsage())
+	}
+	spec, network, err := SetupSpec(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info("loaded spec", "network", network)
+
+	dryRun := ctx.Bool(ResetDryRunFlag.Name)
+	tilesDB, err := fun.OpenDB(ctx.Path(TilesTilesFlag.Name), dryRun, 100, 100)
+	if err != nil {
+		return fmt.Errorf("failed to open tiles db: %w", err)
+	}
+	defer tilesDB.Close()
+
+	return fun.ResetTiles(log, tilesDB, spec, uint8(tileType), common.Epoch(ctx.Uint64(ResetFromEpochFlag.Name)), dryRun)
+}
+
+// SetupOrderings registers the custom validator ordering, if a custom order file is configured.
+func SetupOrderings(ctx *cli.Context, log log.Logger) error {
+	path := ctx.Path(TilesCustomOrderFlag.Name)
+	if path == "" {
+		return nil
+	}
+	ordering, err := fun.LoadCustomOrdering(path)
+	if err != nil {
+		return fmt.Errorf("failed to load custom ordering: %w", err)
+	}
+	fun.RegisterOrdering(fun.OrderingCustom, ordering)
+	log.Info("loaded custom validator ordering", "path", path)
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package beaconapi reads blocks and states from a beacon node, with the standard beacon REST API, in SSZ encoding.
+package beaconapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+// ErrNotFound is returned when the beacon node does not have the requested block or state,
+// e.g. because there is no block at the requested slot.
+var ErrNotFound = errors.New("not found")
+
+// maxSSZResponseSize limits the size of SSZ responses that are read fully, to not run out of memory.
+const maxSSZResponseSize = 1 << 30
+
+type Client struct {
+	// Endpoint is the base URL of the beacon node API, e.g. http://localhost:5052
+	Endpoint string
+	HTTP     *http.Client
+}
+
+func NewClient(endpoint string) *Client {
+	return &Client{Endpoint: strings.TrimSuffix(endpoint, "/"), HTTP: http.DefaultClient}
+}
+
+// get requests the given API path, and returns the response body if the request was successful.
+// The body must be closed after use.
+func (c *Client) get(ctx context.Context, path string, accept string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Endpoint+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request %s: %w", path, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("request %s: %w", path, ErrNotFound)
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("request %s failed with status %d: %s", path, resp.StatusCode, msg)
+	}
+}
+
+// getSSZ requests the given API path in SSZ encoding, and decodes the response into dest.
+func (c *Client) getSSZ(ctx context.Context, path string, dest common.SSZObj) error {
+	body, err := c.get(ctx, path, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, maxSSZResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", path, err)
+	}
+	if err := dest.Deserialize(codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+func blockPath(slot common.Slot) string {
+	return fmt.Sprintf("/eth/v2/beacon/blocks/%d", slot)
+}
+
+func statePath(slot common.Slot) string {
+	return fmt.Sprintf("/eth/v2/debug/beacon/states/%d", slot)
+}
+
+// Block decodes the signed beacon block at the given slot into dest.
+// ErrNotFound is returned if there is no block at the slot.
+func (c *Client) Block(ctx context.Context, slot common.Slot, dest common.SSZObj) error {
+	return c.getSSZ(ctx, blockPath(slot), dest)
+}
+
+// PartialBlock decodes the start of the signed beacon block at the given slot, and the given block body fields,
+// with era.DecodePartialBlock. The rest of the response is not read.
+func (c *Client) PartialBlock(ctx context.Context, slot common.Slot, fields *era.BlockFields) (*era.BlockInfo, error) {
+	body, err := c.get(ctx, blockPath(slot), "application/octet-stream")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	info, err := era.DecodePartialBlock(body, fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode partial block %d: %w", slot, err)
+	}
+	return info, nil
+}
+
+// State decodes the beacon state at the given slot into dest.
+func (c *Client) State(ctx context.Context, slot common.Slot, dest common.SSZObj) error {
+	return c.getSSZ(ctx, statePath(slot), dest)
+}
+
+// PartialState decodes only the selected fields of the beacon state at the given slot,
+// with era.DecodePartialContainer, while streaming the response.
+func (c *Client) PartialState(ctx context.Context, slot common.Slot, typ *view.ContainerTypeDef, fields map[string]codec.Deserializable) error {
+	body, err := c.get(ctx, statePath(slot), "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := era.DecodePartialContainer(body, typ, fields); err != nil {
+		return fmt.Errorf("failed to decode partial state %d: %w", slot, err)
+	}
+	return nil
+}
+
+// FinalizedEpoch returns the finalized epoch of the head state of the beacon node.
+func (c *Client) FinalizedEpoch(ctx context.Context) (common.Epoch, error) {
+	body, err := c.get(ctx, "/eth/v1/beacon/states/head/finality_checkpoints", "application/json")
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	var resp struct {
+		Data struct {
+			Finalized common.Checkpoint `json:"finalized"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return 0, fmt.Errorf("failed to decode finality checkpoints: %w", err)
+	}
+	return resp.Data.Finalized.Epoch, nil
+}
@@ -0,0 +1,179 @@
+package beaconapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+func encodeSSZ(t *testing.T, obj common.SSZObj) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := obj.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		t.Fatalf("failed to encode fixture: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// mockNode serves the given responses by request path, and 404 for any other path.
+func mockNode(t *testing.T, responses map[string][]byte) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if accept := r.Header.Get("Accept"); accept == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+		} else if accept != "application/octet-stream" {
+			http.Error(w, "unexpected accept header "+accept, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return NewClient(srv.URL + "/")
+}
+
+func fixtureBlock() *phase0.SignedBeaconBlock {
+	return &phase0.SignedBeaconBlock{
+		Message: phase0.BeaconBlock{
+			Slot:          10,
+			ProposerIndex: 3,
+			Body: phase0.BeaconBlockBody{
+				Graffiti: common.Root{0: 'h', 1: 'i'},
+				Attestations: phase0.Attestations{
+					{
+						AggregationBits: phase0.AttestationBits{0b0000_0101},
+						Data: phase0.AttestationData{
+							Slot:   9,
+							Index:  1,
+							Target: common.Checkpoint{Epoch: 1, Root: common.Root{1}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestClientBlock(t *testing.T) {
+	spec := configs.Minimal
+	client := mockNode(t, map[string][]byte{
+		"/eth/v2/beacon/blocks/10": encodeSSZ(t, spec.Wrap(fixtureBlock())),
+	})
+
+	var block phase0.SignedBeaconBlock
+	if err := client.Block(context.Background(), 10, spec.Wrap(&block)); err != nil {
+		t.Fatalf("failed to get block: %v", err)
+	}
+	if block.Message.Slot != 10 || block.Message.ProposerIndex != 3 {
+		t.Fatalf("unexpected block: slot %d, proposer %d", block.Message.Slot, block.Message.ProposerIndex)
+	}
+	if len(block.Message.Body.Attestations) != 1 || block.Message.Body.Attestations[0].Data.Index != 1 {
+		t.Fatalf("unexpected attestations: %v", block.Message.Body.Attestations)
+	}
+
+	err := client.Block(context.Background(), 11, spec.Wrap(&block))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error for missing block, got %v", err)
+	}
+}
+
+func TestClientPartialBlock(t *testing.T) {
+	spec := configs.Minimal
+	client := mockNode(t, map[string][]byte{
+		"/eth/v2/beacon/blocks/10": encodeSSZ(t, spec.Wrap(fixtureBlock())),
+	})
+
+	var atts phase0.Attestations
+	info, err := client.PartialBlock(context.Background(), 10, &era.BlockFields{Attestations: spec.Wrap(&atts)})
+	if err != nil {
+		t.Fatalf("failed to get partial block: %v", err)
+	}
+	if info.Slot != 10 || info.ProposerIndex != 3 || info.Graffiti != (common.Root{0: 'h', 1: 'i'}) {
+		t.Fatalf("unexpected block info: %+v", info)
+	}
+	if len(atts) != 1 || atts[0].Data.Slot != 9 || atts[0].Data.Target.Epoch != 1 {
+		t.Fatalf("unexpected attestations: %v", atts)
+	}
+
+	_, err = client.PartialBlock(context.Background(), 11, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error for missing block, got %v", err)
+	}
+}
+
+func TestClientPartialState(t *testing.T) {
+	spec := configs.Minimal
+	// the vectors of the state must have their full length to be encoded
+	state := &phase0.BeaconState{
+		Slot:        64,
+		BlockRoots:  make(phase0.HistoricalBatchRoots, spec.SLOTS_PER_HISTORICAL_ROOT),
+		StateRoots:  make(phase0.HistoricalBatchRoots, spec.SLOTS_PER_HISTORICAL_ROOT),
+		RandaoMixes: make(phase0.RandaoMixes, spec.EPOCHS_PER_HISTORICAL_VECTOR),
+		Slashings:   make(phase0.SlashingsHistory, spec.EPOCHS_PER_SLASHINGS_VECTOR),
+		Validators: phase0.ValidatorRegistry{
+			{EffectiveBalance: 32_000_000_000, ExitEpoch: common.FAR_FUTURE_EPOCH, WithdrawableEpoch: common.FAR_FUTURE_EPOCH},
+			{EffectiveBalance: 31_000_000_000, ActivationEpoch: 2, ExitEpoch: 5, WithdrawableEpoch: 10},
+		},
+	}
+	client := mockNode(t, map[string][]byte{
+		"/eth/v2/debug/beacon/states/64": encodeSSZ(t, spec.Wrap(state)),
+	})
+
+	var slot common.Slot
+	var validators phase0.ValidatorRegistry
+	err := client.PartialState(context.Background(), 64, phase0.BeaconStateType(spec), map[string]codec.Deserializable{
+		"slot":       &slot,
+		"validators": spec.Wrap(&validators),
+	})
+	if err != nil {
+		t.Fatalf("failed to get partial state: %v", err)
+	}
+	if slot != 64 {
+		t.Fatalf("unexpected slot %d", slot)
+	}
+	if len(validators) != 2 || validators[1].ExitEpoch != 5 || validators[1].EffectiveBalance != 31_000_000_000 {
+		t.Fatalf("unexpected validators: %v", validators)
+	}
+
+	err = client.PartialState(context.Background(), 128, phase0.BeaconStateType(spec), map[string]codec.Deserializable{
+		"slot": &slot,
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error for missing state, got %v", err)
+	}
+}
+
+func TestClientFinalizedEpoch(t *testing.T) {
+	client := mockNode(t, map[string][]byte{
+		"/eth/v1/beacon/states/head/finality_checkpoints": []byte(`{"data":{` +
+			`"previous_justified":{"epoch":"40","root":"0x0000000000000000000000000000000000000000000000000000000000000000"},` +
+			`"current_justified":{"epoch":"41","root":"0x0000000000000000000000000000000000000000000000000000000000000000"},` +
+			`"finalized":{"epoch":"39","root":"0x0100000000000000000000000000000000000000000000000000000000000000"}}}`),
+	})
+	epoch, err := client.FinalizedEpoch(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get finalized epoch: %v", err)
+	}
+	if epoch != 39 {
+		t.Fatalf("unexpected finalized epoch %d", epoch)
+	}
+
+	missing := mockNode(t, nil)
+	if _, err := missing.FinalizedEpoch(context.Background()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
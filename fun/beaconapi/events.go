@@ -0,0 +1,98 @@
+package beaconapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+const (
+	TopicHead                = "head"
+	TopicChainReorg          = "chain_reorg"
+	TopicFinalizedCheckpoint = "finalized_checkpoint"
+)
+
+// Event is a server-sent event of the beacon node event stream.
+type Event struct {
+	Topic string
+	Data  json.RawMessage
+}
+
+type HeadEvent struct {
+	Slot            common.Slot `json:"slot"`
+	Block           common.Root `json:"block"`
+	State           common.Root `json:"state"`
+	EpochTransition bool        `json:"epoch_transition"`
+}
+
+type ChainReorgEvent struct {
+	Slot         common.Slot  `json:"slot"`
+	Depth        common.Slot  `json:"depth"`
+	OldHeadBlock common.Root  `json:"old_head_block"`
+	NewHeadBlock common.Root  `json:"new_head_block"`
+	OldHeadState common.Root  `json:"old_head_state"`
+	NewHeadState common.Root  `json:"new_head_state"`
+	Epoch        common.Epoch `json:"epoch"`
+}
+
+type FinalizedCheckpointEvent struct {
+	Block common.Root  `json:"block"`
+	State common.Root  `json:"state"`
+	Epoch common.Epoch `json:"epoch"`
+}
+
+// maxEventSize limits the size of a single event stream line.
+const maxEventSize = 1 << 20
+
+// Events subscribes to the event stream of the beacon node for the given topics,
+// and calls fn for each event, until the stream ends, the context is canceled, or fn returns an error.
+func (c *Client) Events(ctx context.Context, topics []string, fn func(ev *Event) error) error {
+	body, err := c.get(ctx, "/eth/v1/events?topics="+strings.Join(topics, ","), "text/event-stream")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 4096), maxEventSize)
+	var ev Event
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// an empty line dispatches the event
+			if ev.Topic != "" {
+				ev.Data = json.RawMessage(strings.Join(data, "\n"))
+				if err := fn(&ev); err != nil {
+					return err
+				}
+			}
+			ev = Event{}
+			data = data[:0]
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// comment, e.g. keep-alive
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Topic = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read event stream: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("event stream ended")
+}
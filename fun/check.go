@@ -0,0 +1,153 @@
+package fun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/electra"
+
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+// maxReportedMismatches limits the number of mismatching validators that are logged per epoch.
+const maxReportedMismatches = 100
+
+func flagsString(flags altair.ParticipationFlags) string {
+	var out []string
+	for _, f := range []struct {
+		flag altair.ParticipationFlags
+		name string
+	}{
+		{altair.TIMELY_SOURCE_FLAG, "source"},
+		{altair.TIMELY_TARGET_FLAG, "target"},
+		{altair.TIMELY_HEAD_FLAG, "head"},
+	} {
+		if flags&f.flag != 0 {
+			out = append(out, f.name)
+		}
+	}
+	if len(out) == 0 {
+		return "none"
+	}
+	return strings.Join(out, ",")
+}
+
+// CheckPerf cross-validates the validator performance computation against the participation flags of the chain.
+//
+// Each era state since Altair has the previous_epoch_participation flags of the last epoch of the era.
+// The flags only include the attestations that were included in the blocks of that epoch,
+// since the era state is at the epoch boundary. The validator performance of the epoch is computed
+// from the same blocks, converted to participation flags, and compared per validator.
+// The current_epoch_participation of an era state is always empty, since no blocks of the epoch are processed yet.
+//
+// Era boundary epochs in the given range are checked. An error is returned if any flags mismatch.
+func CheckPerf(ctx context.Context, log log.Logger, spec *common.Spec, st *era.Store, start, end common.Epoch) error {
+	epochsPerEra := spec.SlotToEpoch(era.SlotsPerEra)
+	first := start
+	if rem := first % epochsPerEra; rem != 0 {
+		first += epochsPerEra - rem
+	}
+	// the previous epoch of the era state must have participation flags
+	if min := spec.ALTAIR_FORK_EPOCH + 1; first < min {
+		first = min
+		if rem := first % epochsPerEra; rem != 0 {
+			first += epochsPerEra - rem
+		}
+	}
+	checked, mismatched := 0, 0
+	for eraEpoch := first; eraEpoch <= end && eraEpoch >= first; eraEpoch += epochsPerEra {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("stopped before checking epoch %d: %w", eraEpoch-1, err)
+		}
+		count, err := checkEraPerf(log, spec, st, eraEpoch)
+		if errors.Is(err, era.ErrNotExist) {
+			log.Warn("skipping epoch, era is not available", "epoch", eraEpoch-1, "err", err)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to check epoch %d: %w", eraEpoch-1, err)
+		}
+		checked++
+		if count > 0 {
+			mismatched++
+		}
+	}
+	if checked == 0 {
+		return fmt.Errorf("no era boundary epochs to check, since Altair, in range %d - %d", start, end)
+	}
+	if mismatched > 0 {
+		return fmt.Errorf("validator performance mismatches participation flags in %d of %d checked epochs", mismatched, checked)
+	}
+	log.Info("validator performance matches participation flags", "checked_epochs", checked)
+	return nil
+}
+
+// checkEraPerf checks the last epoch of the era before the given era boundary epoch, see CheckPerf.
+// It returns the number of mismatching validators.
+func checkEraPerf(log log.Logger, spec *common.Spec, st *era.Store, eraEpoch common.Epoch) (int, error) {
+	eraSlot, err := spec.EpochStartSlot(eraEpoch)
+	if err != nil {
+		return 0, fmt.Errorf("bad era epoch %d: %w", eraEpoch, err)
+	}
+	epoch := eraEpoch - 1
+	state, err := DecodePartialState(spec, st.PartialState, eraSlot, "block_roots", "randao_mixes", "validators",
+		"previous_epoch_participation")
+	if err != nil {
+		return 0, err
+	}
+	blockRootFn := stateBlockRoots(eraSlot, state.BlockRoots, nil)
+	randaoFn := stateRandao(spec, eraEpoch, state.RandaoMixes)
+	indicesBounded := loadIndicesFromState(state.Validators)
+	attFn := AttestationsLookup(func(slot common.Slot) (electra.Attestations, error) {
+		// the blocks after the era state are not included in the participation flags
+		if slot >= eraSlot {
+			return nil, nil
+		}
+		block, err := DecodePartialBlock(spec, st.PartialBlock, slot)
+		if errors.Is(err, era.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return block.Attestations, nil
+	})
+	perfs, _, err := processPerf(spec, blockRootFn, attFn, randaoFn, indicesBounded, nil, eraEpoch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to process validator performance: %w", err)
+	}
+
+	var flagMismatches [3]int
+	mismatches := 0
+	for vi, onChain := range state.PreviousEpochParticipation {
+		var perf ValidatorPerformance
+		if vi < len(perfs) {
+			perf = perfs[vi]
+		}
+		computed := participationFlags(spec, perf, epoch)
+		onChain &= altair.TIMELY_SOURCE_FLAG | altair.TIMELY_TARGET_FLAG | altair.TIMELY_HEAD_FLAG
+		if computed == onChain {
+			continue
+		}
+		for i, flag := range []altair.ParticipationFlags{altair.TIMELY_SOURCE_FLAG, altair.TIMELY_TARGET_FLAG, altair.TIMELY_HEAD_FLAG} {
+			if computed&flag != onChain&flag {
+				flagMismatches[i]++
+			}
+		}
+		if mismatches < maxReportedMismatches {
+			log.Warn("participation mismatch", "epoch", epoch, "validator", vi,
+				"computed", flagsString(computed), "on_chain", flagsString(onChain), "perf", fmt.Sprintf("%08x", uint32(perf)))
+		}
+		mismatches++
+	}
+	if mismatches > 0 {
+		log.Error("validator performance mismatches participation flags", "epoch", epoch, "validators", mismatches,
+			"source", flagMismatches[0], "target", flagMismatches[1], "head", flagMismatches[2])
+	} else {
+		log.Info("validator performance matches participation flags", "epoch", epoch, "validators", len(state.PreviousEpochParticipation))
+	}
+	return mismatches, nil
+}
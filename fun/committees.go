@@ -0,0 +1,186 @@
+package fun
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// KeyCommitteePerf is a:
+	// 3 byte prefix for per-epoch committee-ordered performance keying, followed by:
+	// 8 byte big-endian epoch value.
+	//
+	// The epoch key is the same as for KeyPerf: epoch e has the performance of the attestations of epoch e-1.
+	//
+	// Values under this key are snappy block-compressed.
+	//
+	// The value is a header, followed by the []ValidatorPerformance of the committee members,
+	// in order of slot, committee index, and position in the committee. The header is:
+	// 2 byte little-endian number of slots, 2 byte little-endian number of committees per slot,
+	// and a 4 byte little-endian start offset into the performance values per committee, in the same order.
+	// Each performance value is encoded as 4 byte little-endian.
+	KeyCommitteePerf string = "cpf"
+
+	// KeyActiveValidators is a:
+	// 3 byte prefix for per-epoch active validator set keying, followed by:
+	// 8 byte big-endian epoch value.
+	//
+	// The epoch key is the same as for KeyPerf: epoch e has the active validators of epoch e-1.
+	//
+	// Values under this key are snappy block-compressed.
+	//
+	// The value is the active validators in order of validator index, each encoded as
+	// uvarint validator index delta to the previous active validator (or the index itself for the first),
+	// and varint activation epoch delta to the previous active validator (or the activation epoch itself for the first).
+	KeyActiveValidators string = "act"
+)
+
+// CommitteePerf is the validator performance of an epoch, in the shuffled order of the committees.
+type CommitteePerf struct {
+	Slots             uint64
+	CommitteesPerSlot uint64
+	// Offsets has the start of each committee in Perf, by slot index * CommitteesPerSlot + committee index.
+	Offsets []uint32
+	Perf    []ValidatorPerformance
+}
+
+// committeeOrder lays out the validator performance in the committee order of the shuffling.
+func committeeOrder(shuf *common.ShufflingEpoch, perfs []ValidatorPerformance) *CommitteePerf {
+	out := &CommitteePerf{
+		Slots: uint64(len(shuf.Committees)),
+		Perf:  make([]ValidatorPerformance, 0, len(shuf.ActiveIndices)),
+	}
+	if out.Slots > 0 {
+		out.CommitteesPerSlot = uint64(len(shuf.Committees[0]))
+	}
+	out.Offsets = make([]uint32, 0, out.Slots*out.CommitteesPerSlot)
+	for _, slotComms := range shuf.Committees {
+		for _, comm := range slotComms {
+			out.Offsets = append(out.Offsets, uint32(len(out.Perf)))
+			for _, vi := range comm {
+				var perf ValidatorPerformance
+				if uint64(vi) < uint64(len(perfs)) {
+					perf = perfs[vi]
+				}
+				out.Perf = append(out.Perf, perf)
+			}
+		}
+	}
+	return out
+}
+
+func encodeCommitteePerf(c *CommitteePerf) []byte {
+	headerSize := 2 + 2 + 4*len(c.Offsets)
+	out := make([]byte, headerSize+4*len(c.Perf))
+	binary.LittleEndian.PutUint16(out[0:2], uint16(c.Slots))
+	binary.LittleEndian.PutUint16(out[2:4], uint16(c.CommitteesPerSlot))
+	for i, offset := range c.Offsets {
+		binary.LittleEndian.PutUint32(out[4+i*4:4+i*4+4], offset)
+	}
+	for i, v := range c.Perf {
+		binary.LittleEndian.PutUint32(out[headerSize+i*4:headerSize+i*4+4], uint32(v))
+	}
+	return snappy.Encode(nil, out)
+}
+
+func getCommitteePerf(perfDB *leveldb.DB, currEp common.Epoch) (*CommitteePerf, error) {
+	var key [3 + 8]byte
+	copy(key[:3], KeyCommitteePerf)
+	binary.BigEndian.PutUint64(key[3:], uint64(currEp))
+	data, err := perfDB.Get(key[:], nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err = snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress committee performance of epoch %d: %w", currEp, err)
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("committee performance data of epoch %d too short: %d", currEp, len(data))
+	}
+	out := &CommitteePerf{
+		Slots:             uint64(binary.LittleEndian.Uint16(data[0:2])),
+		CommitteesPerSlot: uint64(binary.LittleEndian.Uint16(data[2:4])),
+	}
+	count := out.Slots * out.CommitteesPerSlot
+	headerSize := 4 + 4*count
+	if uint64(len(data)) < headerSize || (uint64(len(data))-headerSize)%4 != 0 {
+		return nil, fmt.Errorf("invalid committee performance data length %d of epoch %d", len(data), currEp)
+	}
+	out.Offsets = make([]uint32, count)
+	for i := range out.Offsets {
+		out.Offsets[i] = binary.LittleEndian.Uint32(data[4+i*4 : 4+i*4+4])
+	}
+	perf := data[headerSize:]
+	out.Perf = make([]ValidatorPerformance, len(perf)/4)
+	for i := range out.Perf {
+		out.Perf[i] = ValidatorPerformance(binary.LittleEndian.Uint32(perf[i*4 : i*4+4]))
+	}
+	for i, offset := range out.Offsets {
+		if offset > uint32(len(out.Perf)) || (i > 0 && offset < out.Offsets[i-1]) {
+			return nil, fmt.Errorf("invalid committee offset %d of committee %d in epoch %d", offset, i, currEp)
+		}
+	}
+	return out, nil
+}
+
+// ActiveValidators is the active validator set of an epoch.
+type ActiveValidators struct {
+	// Indices are the active validator indices, in increasing order.
+	Indices []common.ValidatorIndex
+	// Activations are the activation epochs of the active validators, by position in Indices.
+	Activations []common.Epoch
+}
+
+func encodeActiveValidators(active []common.ValidatorIndex, indicesBounded []common.BoundedIndex) []byte {
+	out := make([]byte, 0, len(active)*2)
+	var buf [binary.MaxVarintLen64]byte
+	prevIndex, prevActivation := uint64(0), int64(0)
+	for _, vi := range active {
+		activation := int64(indicesBounded[vi].Activation)
+		n := binary.PutUvarint(buf[:], uint64(vi)-prevIndex)
+		out = append(out, buf[:n]...)
+		n = binary.PutVarint(buf[:], activation-prevActivation)
+		out = append(out, buf[:n]...)
+		prevIndex, prevActivation = uint64(vi), activation
+	}
+	// indices are mostly consecutive and activations are close, the deltas compress well
+	return snappy.Encode(nil, out)
+}
+
+func getActiveValidators(perfDB *leveldb.DB, currEp common.Epoch) (*ActiveValidators, error) {
+	var key [3 + 8]byte
+	copy(key[:3], KeyActiveValidators)
+	binary.BigEndian.PutUint64(key[3:], uint64(currEp))
+	data, err := perfDB.Get(key[:], nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err = snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress active validators of epoch %d: %w", currEp, err)
+	}
+	out := &ActiveValidators{}
+	prevIndex, prevActivation := uint64(0), int64(0)
+	for len(data) > 0 {
+		indexDelta, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid validator index of active validator %d in epoch %d", len(out.Indices), currEp)
+		}
+		data = data[n:]
+		activationDelta, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid activation epoch of active validator %d in epoch %d", len(out.Indices), currEp)
+		}
+		data = data[n:]
+		prevIndex += indexDelta
+		prevActivation += activationDelta
+		out.Indices = append(out.Indices, common.ValidatorIndex(prevIndex))
+		out.Activations = append(out.Activations, common.Epoch(prevActivation))
+	}
+	return out, nil
+}
@@ -0,0 +1,95 @@
+package era
+
+import (
+	"fmt"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+type DiagnosticKind uint8
+
+const (
+	// DiagnosticGap is a range of eras missing between other eras of the store.
+	DiagnosticGap DiagnosticKind = iota
+	// DiagnosticDuplicate is an era file with the same state slot as another era file of the store.
+	// Only the first indexed file is used.
+	DiagnosticDuplicate
+	// DiagnosticFilename is an era file with a file name that does not match its contents.
+	DiagnosticFilename
+)
+
+func (k DiagnosticKind) String() string {
+	switch k {
+	case DiagnosticGap:
+		return "gap"
+	case DiagnosticDuplicate:
+		return "duplicate"
+	case DiagnosticFilename:
+		return "filename"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(k))
+	}
+}
+
+// Diagnostic is a problem with the era files of a store.
+type Diagnostic struct {
+	Kind DiagnosticKind
+	// Slot is the state starting-slot of the era file, or of the first missing era of a gap.
+	Slot common.Slot
+	// Path is the path of the era file, empty for gaps.
+	Path string
+	Msg  string
+}
+
+func (d Diagnostic) String() string {
+	if d.Path == "" {
+		return fmt.Sprintf("%s: era %d: %s", d.Kind, d.Slot/SlotsPerEra, d.Msg)
+	}
+	return fmt.Sprintf("%s: era %d (%s): %s", d.Kind, d.Slot/SlotsPerEra, d.Path, d.Msg)
+}
+
+// EraRange is a range of consecutive eras, by state starting-slot, both inclusive.
+// The blocks of the range start at First-SlotsPerEra, or at First for the genesis era.
+type EraRange struct {
+	First common.Slot
+	Last  common.Slot
+}
+
+func (r EraRange) String() string {
+	return fmt.Sprintf("eras %d - %d", r.First/SlotsPerEra, r.Last/SlotsPerEra)
+}
+
+// Contains returns if the era with the given state slot is part of the range.
+func (r EraRange) Contains(stateSlot common.Slot) bool {
+	return r.First <= stateSlot && stateSlot <= r.Last
+}
+
+// Coverage returns the ranges of consecutive eras of the store, in increasing order.
+func (s *Store) Coverage() []EraRange {
+	var out []EraRange
+	for _, slot := range s.Slots() {
+		if n := len(out); n > 0 && out[n-1].Last+SlotsPerEra == slot {
+			out[n-1].Last = slot
+		} else {
+			out = append(out, EraRange{First: slot, Last: slot})
+		}
+	}
+	return out
+}
+
+// Diagnostics returns the problems found while indexing era files, and the gaps between the eras of the store.
+func (s *Store) Diagnostics() []Diagnostic {
+	s.lock.Lock()
+	out := append([]Diagnostic(nil), s.diagnostics...)
+	s.lock.Unlock()
+	ranges := s.Coverage()
+	for i := 1; i < len(ranges); i++ {
+		first, last := ranges[i-1].Last+SlotsPerEra, ranges[i].First-SlotsPerEra
+		out = append(out, Diagnostic{
+			Kind: DiagnosticGap,
+			Slot: first,
+			Msg:  fmt.Sprintf("missing eras %d - %d", first/SlotsPerEra, last/SlotsPerEra),
+		})
+	}
+	return out
+}
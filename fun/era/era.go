@@ -132,11 +132,11 @@ func ReadBlockOffset(f io.ReadSeeker, i uint64) (int64, error) {
 	if err != nil {
 		return 0, fmt.Errorf("failed to read offset: %w", err)
 	}
-	result := n - x + offset
-	if result == 0 {
+	// a zero offset means there is no block at the slot
+	if offset == 0 {
 		return 0, ErrNotExist
 	}
-	return result, nil
+	return n - x + offset, nil
 }
 
 // ReadStateOffsetAndSlot reads the file offset of the state.
@@ -238,3 +238,38 @@ func SeekState(f io.ReadSeeker, groupEnd int64) (slot common.Slot, err error) {
 	}
 	return v, nil
 }
+
+// ReadBlockOffsets reads the file offsets of all blocks of the group, 0 for slots without block.
+// Seeker f must be positioned at the end of a group, and the group must have a block slot-index.
+// Returns offsets relative to start of file.
+func ReadBlockOffsets(f io.ReadSeeker) ([]int64, error) {
+	n, err := f.Seek(-stateSlotIndexSize-blockSlotIndexSize, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup block slot-index: %w", err)
+	}
+	typ, length, err := ReadHeader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block slot-index header: %w", err)
+	}
+	if typ != SlotIndexType {
+		return nil, fmt.Errorf("expected block slot-index type, got %x", typ)
+	}
+	if length != blockSlotIndexSize-headerSize {
+		return nil, fmt.Errorf("unexpected block slot-index size: %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, fmt.Errorf("failed to read block slot-index: %w", err)
+	}
+	if count := binary.LittleEndian.Uint64(data[length-8:]); count != SlotsPerEra {
+		return nil, fmt.Errorf("unexpected number of blocks: %d", count)
+	}
+	offsets := make([]int64, SlotsPerEra)
+	for i := range offsets {
+		offset := int64(binary.LittleEndian.Uint64(data[8+8*i : 8+8*i+8]))
+		if offset != 0 {
+			offsets[i] = n + offset
+		}
+	}
+	return offsets, nil
+}
@@ -0,0 +1,284 @@
+package era
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// The persisted index of a store is a snappy-framed stream of:
+//
+//	magic | version | identity | count | entry*
+//	entry := path | size | mod-time | state starting-slot | state offset | blocks
+//	blocks := count | block offset*
+//
+// Numbers are varint encoded. Strings are length-prefixed.
+// Block offsets are relative to the previous block offset, to encode compactly; 0 if no block at the slot.
+const (
+	indexMagic   = "eraindex"
+	indexVersion = 1
+)
+
+// fileMeta identifies the version of an era file, to detect changes.
+type fileMeta struct {
+	size    int64
+	modTime int64
+}
+
+// cachedIndex is the persisted slot-index data of an era file.
+type cachedIndex struct {
+	meta  fileMeta
+	slot  common.Slot
+	state int64
+	// encoded block offsets
+	blocks []byte
+}
+
+func newCachedIndex(meta fileMeta, slot common.Slot, idx *eraIndex) *cachedIndex {
+	blocks := binary.AppendUvarint(nil, uint64(len(idx.blocks)))
+	prev := int64(0)
+	for _, offset := range idx.blocks {
+		if offset == 0 {
+			blocks = binary.AppendUvarint(blocks, 0)
+			continue
+		}
+		// blocks are in increasing order, after the version entry, so the delta is never 0
+		blocks = binary.AppendUvarint(blocks, uint64(offset-prev))
+		prev = offset
+	}
+	return &cachedIndex{meta: meta, slot: slot, state: idx.state, blocks: blocks}
+}
+
+func (c *cachedIndex) decode() (*eraIndex, error) {
+	idx := &eraIndex{size: c.meta.size, state: c.state}
+	data := c.blocks
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > SlotsPerEra {
+		return nil, fmt.Errorf("invalid block count")
+	}
+	data = data[n:]
+	if count == 0 {
+		return idx, nil
+	}
+	idx.blocks = make([]int64, count)
+	prev := int64(0)
+	for i := range idx.blocks {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid block offset %d", i)
+		}
+		data = data[n:]
+		if delta == 0 {
+			continue
+		}
+		prev += int64(delta)
+		if prev >= c.meta.size {
+			return nil, fmt.Errorf("block offset %d out of range: %d", i, prev)
+		}
+		idx.blocks[i] = prev
+	}
+	return idx, nil
+}
+
+// identity identifies the store directory, to not use the index of another era store.
+func (s *Store) identity() string {
+	dir := s.Dir
+	if abs, err := filepath.Abs(dir); err == nil && dir != "" {
+		dir = abs
+	}
+	return dir + "\x00" + s.root
+}
+
+// SaveIndex writes the slot-indices of all indexed era files, to speed up loading the store later, see LoadIndex.
+// Era files that have not been read yet are read, to complete the index.
+func (s *Store) SaveIndex(w io.Writer) error {
+	type entry struct {
+		path string
+		idx  *cachedIndex
+	}
+	s.lock.Lock()
+	// include duplicate era files, to not read them again
+	entries := make([]entry, 0, len(s.paths))
+	for p, slot := range s.paths {
+		if c, ok := s.cached[p]; ok && c.meta == s.meta[p] && c.slot == slot {
+			entries = append(entries, entry{path: p, idx: c})
+		} else if idx, ok := s.indices[slot]; ok && s.files[slot] == p {
+			entries = append(entries, entry{path: p, idx: newCachedIndex(s.meta[p], slot, idx)})
+		} else {
+			entries = append(entries, entry{path: p})
+		}
+	}
+	s.lock.Unlock()
+
+	for i, e := range entries {
+		if e.idx != nil {
+			continue
+		}
+		f, size, err := openFile(s.FS, e.path)
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", e.path, err)
+		}
+		idx, slot, err := readIndex(f, size)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read era %q index: %w", e.path, err)
+		}
+		s.lock.Lock()
+		meta := s.meta[e.path]
+		s.lock.Unlock()
+		entries[i].idx = newCachedIndex(meta, slot, idx)
+	}
+
+	sw := snappy.NewBufferedWriter(w)
+	var buf []byte
+	putString := func(v string) {
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+	}
+	buf = append(buf, indexMagic...)
+	buf = binary.AppendUvarint(buf, indexVersion)
+	putString(s.identity())
+	buf = binary.AppendUvarint(buf, uint64(len(entries)))
+	for _, e := range entries {
+		putString(e.path)
+		buf = binary.AppendVarint(buf, e.idx.meta.size)
+		buf = binary.AppendVarint(buf, e.idx.meta.modTime)
+		buf = binary.AppendUvarint(buf, uint64(e.idx.slot))
+		buf = binary.AppendVarint(buf, e.idx.state)
+		buf = append(buf, e.idx.blocks...)
+		if _, err := sw.Write(buf); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+		buf = buf[:0]
+	}
+	if _, err := sw.Write(buf); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return sw.Close()
+}
+
+// LoadIndex reads an index written by SaveIndex.
+// Era files in the index are not read when indexing the store, unless their size or modification time changed.
+// An index of another era store directory is ignored.
+func (s *Store) LoadIndex(r io.Reader) error {
+	br := bufio.NewReader(snappy.NewReader(r))
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return "", err
+		}
+		if n > 1<<16 {
+			return "", fmt.Errorf("string too long: %d", n)
+		}
+		v := make([]byte, n)
+		if _, err := io.ReadFull(br, v); err != nil {
+			return "", err
+		}
+		return string(v), nil
+	}
+	var magic [len(indexMagic)]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return fmt.Errorf("failed to read index magic: %w", err)
+	}
+	if string(magic[:]) != indexMagic {
+		return fmt.Errorf("not an era store index")
+	}
+	if v, err := binary.ReadUvarint(br); err != nil {
+		return fmt.Errorf("failed to read index version: %w", err)
+	} else if v != indexVersion {
+		return fmt.Errorf("unsupported index version %d", v)
+	}
+	if id, err := readString(); err != nil {
+		return fmt.Errorf("failed to read index identity: %w", err)
+	} else if id != s.identity() {
+		return nil
+	}
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fmt.Errorf("failed to read index entry count: %w", err)
+	}
+	cached := make(map[string]*cachedIndex)
+	for i := uint64(0); i < count; i++ {
+		p, err := readString()
+		if err != nil {
+			return fmt.Errorf("failed to read index entry %d path: %w", i, err)
+		}
+		var c cachedIndex
+		if c.meta.size, err = binary.ReadVarint(br); err != nil {
+			return fmt.Errorf("failed to read index entry %d: %w", i, err)
+		}
+		if c.meta.modTime, err = binary.ReadVarint(br); err != nil {
+			return fmt.Errorf("failed to read index entry %d: %w", i, err)
+		}
+		slot, err := binary.ReadUvarint(br)
+		if err != nil {
+			return fmt.Errorf("failed to read index entry %d: %w", i, err)
+		}
+		c.slot = common.Slot(slot)
+		if c.state, err = binary.ReadVarint(br); err != nil {
+			return fmt.Errorf("failed to read index entry %d: %w", i, err)
+		}
+		blockCount, err := binary.ReadUvarint(br)
+		if err != nil {
+			return fmt.Errorf("failed to read index entry %d block count: %w", i, err)
+		}
+		if blockCount > SlotsPerEra {
+			return fmt.Errorf("invalid index entry %d block count: %d", i, blockCount)
+		}
+		c.blocks = binary.AppendUvarint(nil, blockCount)
+		for j := uint64(0); j < blockCount; j++ {
+			delta, err := binary.ReadUvarint(br)
+			if err != nil {
+				return fmt.Errorf("failed to read index entry %d block %d: %w", i, j, err)
+			}
+			c.blocks = binary.AppendUvarint(c.blocks, delta)
+		}
+		cached[p] = &c
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for p, c := range cached {
+		s.cached[p] = c
+	}
+	return nil
+}
+
+// ReadIndexFile reads the index file at the given OS path with LoadIndex. A missing index file is ignored.
+func (s *Store) ReadIndexFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.LoadIndex(f)
+}
+
+// WriteIndexFile writes the index file at the given OS path with SaveIndex.
+// The index is written to a temporary file first, and then moved into place.
+func (s *Store) WriteIndexFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := s.SaveIndex(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
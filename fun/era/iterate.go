@@ -0,0 +1,111 @@
+package era
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// ErrStop can be returned by an IterateFn to stop iterating early, without error.
+var ErrStop = errors.New("stop iteration")
+
+// IterateFn is called for each entry of a group, in file order.
+// The slot is the block slot for blocks, the state slot for the era-state,
+// the starting-slot for slot-indices, and 0 for other entries.
+// The reader provides the raw entry data, and is only valid during the call.
+// Unread entry data is skipped.
+type IterateFn func(slot common.Slot, typ EntryType, r io.Reader) error
+
+// iterateBufferSize is the read buffer size used to walk through era files.
+const iterateBufferSize = 1 << 20
+
+// Iterate walks all entries of the era file front to back, with a single sequential read,
+// after reading the slot-indices at the end of the file.
+// Only era files with a single group are supported.
+func Iterate(f io.ReadSeeker, fn IterateFn) error {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek era to end: %w", err)
+	}
+	_, stateSlot, err := ReadStateOffsetAndSlot(f)
+	if err != nil {
+		return fmt.Errorf("failed to read state slot-index: %w", err)
+	}
+	var blocks []int64
+	if stateSlot != 0 {
+		if _, err := f.Seek(size, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek era to end: %w", err)
+		}
+		blocks, err = ReadBlockOffsets(f)
+		if err != nil {
+			return fmt.Errorf("failed to read block slot-index: %w", err)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek era to start: %w", err)
+	}
+	return iterate(f, stateSlot, blocks, fn)
+}
+
+// Iterate walks all entries of the era with the given state slot, see Iterate.
+func (s *Store) Iterate(slot common.Slot, fn IterateFn) error {
+	h, err := s.acquire(slot)
+	if err != nil {
+		return fmt.Errorf("failed to open era: %w", err)
+	}
+	defer s.release(h)
+	return iterate(io.NewSectionReader(h.f, 0, h.idx.size), slot, h.idx.blocks, fn)
+}
+
+func iterate(r io.Reader, stateSlot common.Slot, blocks []int64, fn IterateFn) error {
+	blockSlots := make(map[int64]common.Slot, len(blocks))
+	startSlot := stateSlot - common.Slot(len(blocks))
+	for i, offset := range blocks {
+		if offset != 0 {
+			blockSlots[offset] = startSlot + common.Slot(i)
+		}
+	}
+	br := bufio.NewReaderSize(r, iterateBufferSize)
+	offset := int64(0)
+	for {
+		typ, length, err := ReadHeader(br)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read entry header at offset %d: %w", offset, err)
+		}
+		var slot common.Slot
+		switch typ {
+		case CompressedSignedBeaconBlockType:
+			s, ok := blockSlots[offset]
+			if !ok {
+				return fmt.Errorf("block at offset %d is not in the block slot-index", offset)
+			}
+			slot = s
+		case CompressedBeaconStateType:
+			slot = stateSlot
+		case SlotIndexType:
+			if length == blockSlotIndexSize-headerSize {
+				slot = startSlot
+			} else {
+				slot = stateSlot
+			}
+		}
+		lr := &io.LimitedReader{R: br, N: int64(length)}
+		if err := fn(slot, typ, lr); errors.Is(err, ErrStop) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to process entry %x at offset %d: %w", typ, offset, err)
+		}
+		if lr.N > 0 {
+			if _, err := br.Discard(int(lr.N)); err != nil {
+				return fmt.Errorf("failed to skip remainder of entry at offset %d: %w", offset, err)
+			}
+		}
+		offset += headerSize + int64(length)
+	}
+}
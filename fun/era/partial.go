@@ -0,0 +1,306 @@
+package era
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/view"
+)
+
+// SSZ layout of the start of a signed beacon block, common to all forks:
+//
+//	SignedBeaconBlock := offset(message) | signature
+//	BeaconBlock := slot | proposer_index | parent_root | state_root | offset(body)
+//	BeaconBlockBody := randao_reveal | eth1_data | graffiti |
+//	  offset(proposer_slashings) | offset(attester_slashings) | offset(attestations) | offset(deposits) |
+//	  offset(voluntary_exits) | sync_aggregate (since Altair) | ...
+//
+// The variable-size contents follow the fixed-size parts in field order,
+// so the slashings and attestations can be decoded without decompressing the rest of the block,
+// such as the execution payload.
+const (
+	signedBlockFixedSize = 4 + 96
+	blockFixedSize       = 8 + 8 + 32 + 32 + 4
+	// up to and including the deposits offset
+	bodyPrefixSize = 96 + (32 + 8 + 32) + 32 + 4*4
+
+	graffitiOffset          = 96 + (32 + 8 + 32)
+	proposerSlashingsOffset = graffitiOffset + 32
+	attesterSlashingsOffset = proposerSlashingsOffset + 4
+	attestationsOffset      = attesterSlashingsOffset + 4
+	depositsOffset          = attestationsOffset + 4
+	// the sync aggregate is the first fixed-size field after the offsets
+	syncAggregateOffset = depositsOffset + 4 + 4
+)
+
+// BlockInfo is the partially decoded data of a signed beacon block.
+type BlockInfo struct {
+	Slot          common.Slot
+	ProposerIndex common.ValidatorIndex
+	Graffiti      common.Root
+}
+
+// BlockFields are the destinations of the block body fields to decode with DecodePartialBlock.
+// The fork-specific types determine how the fields are decoded. Nil fields are skipped.
+type BlockFields struct {
+	// SyncAggregate is decoded if the block body has one, i.e. since Altair.
+	SyncAggregate     common.SSZObj
+	ProposerSlashings common.SSZObj
+	AttesterSlashings common.SSZObj
+	Attestations      common.SSZObj
+}
+
+// DecodePartialBlock decodes the slot, proposer index and graffiti of a SSZ encoded signed beacon block,
+// and the given block body fields, if fields is not nil.
+// Reading stops at the end of the last decoded field.
+func DecodePartialBlock(r io.Reader, fields *BlockFields) (*BlockInfo, error) {
+	var fixed [signedBlockFixedSize + blockFixedSize + bodyPrefixSize]byte
+	signed := fixed[:signedBlockFixedSize]
+	if _, err := io.ReadFull(r, signed); err != nil {
+		return nil, fmt.Errorf("failed to read signed block: %w", err)
+	}
+	if offset := binary.LittleEndian.Uint32(signed[0:4]); offset != signedBlockFixedSize {
+		return nil, fmt.Errorf("unexpected message offset: %d", offset)
+	}
+	msg := fixed[signedBlockFixedSize : signedBlockFixedSize+blockFixedSize]
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+	if offset := binary.LittleEndian.Uint32(msg[blockFixedSize-4:]); offset != blockFixedSize {
+		return nil, fmt.Errorf("unexpected body offset: %d", offset)
+	}
+	info := &BlockInfo{
+		Slot:          common.Slot(binary.LittleEndian.Uint64(msg[0:8])),
+		ProposerIndex: common.ValidatorIndex(binary.LittleEndian.Uint64(msg[8:16])),
+	}
+	body := fixed[signedBlockFixedSize+blockFixedSize:]
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("failed to read block body: %w", err)
+	}
+	copy(info.Graffiti[:], body[graffitiOffset:graffitiOffset+32])
+	if fields == nil {
+		return info, nil
+	}
+	// position in the body
+	pos := uint32(bodyPrefixSize)
+	// the first offset is the end of the fixed-size part of the body
+	bodyFixedSize := binary.LittleEndian.Uint32(body[proposerSlashingsOffset : proposerSlashingsOffset+4])
+
+	if fields.SyncAggregate != nil && bodyFixedSize > syncAggregateOffset {
+		if _, err := io.CopyN(io.Discard, r, int64(syncAggregateOffset-pos)); err != nil {
+			return nil, fmt.Errorf("failed to skip to sync aggregate: %w", err)
+		}
+		pos = syncAggregateOffset
+		size := uint32(fields.SyncAggregate.FixedLength())
+		if pos+size > bodyFixedSize {
+			return nil, fmt.Errorf("sync aggregate of %d bytes does not fit in block body of %d fixed bytes", size, bodyFixedSize)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read sync aggregate: %w", err)
+		}
+		pos += size
+		dr := codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))
+		if err := fields.SyncAggregate.Deserialize(dr); err != nil {
+			return nil, fmt.Errorf("failed to deserialize sync aggregate: %w", err)
+		}
+	}
+
+	// the variable-size fields, in body order, with the offsets of their start and end
+	variable := []struct {
+		name       string
+		dest       common.SSZObj
+		startIndex uint32
+		endIndex   uint32
+	}{
+		{"proposer slashings", fields.ProposerSlashings, proposerSlashingsOffset, attesterSlashingsOffset},
+		{"attester slashings", fields.AttesterSlashings, attesterSlashingsOffset, attestationsOffset},
+		{"attestations", fields.Attestations, attestationsOffset, depositsOffset},
+	}
+	for _, field := range variable {
+		if field.dest == nil {
+			continue
+		}
+		start := binary.LittleEndian.Uint32(body[field.startIndex : field.startIndex+4])
+		end := binary.LittleEndian.Uint32(body[field.endIndex : field.endIndex+4])
+		if start < bodyFixedSize || start < pos || end < start {
+			return nil, fmt.Errorf("invalid %s offsets: %d - %d", field.name, start, end)
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(start-pos)); err != nil {
+			return nil, fmt.Errorf("failed to skip to %s: %w", field.name, err)
+		}
+		data := make([]byte, end-start)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", field.name, err)
+		}
+		pos = end
+		dr := codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))
+		if err := field.dest.Deserialize(dr); err != nil {
+			return nil, fmt.Errorf("failed to deserialize %s: %w", field.name, err)
+		}
+	}
+	return info, nil
+}
+
+// PartialBlock decodes the block at the given slot with DecodePartialBlock,
+// decompressing only the start of the block, up to the end of the last decoded field.
+func (s *Store) PartialBlock(slot common.Slot, fields *BlockFields) (*BlockInfo, error) {
+	eraSlot := slot - (slot % SlotsPerEra) + SlotsPerEra
+	h, err := s.acquire(eraSlot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open era: %w", err)
+	}
+	defer s.release(h)
+	offset := h.idx.blocks[slot%SlotsPerEra]
+	if offset == 0 {
+		return nil, fmt.Errorf("failed to seek era to block: %w", ErrNotExist)
+	}
+	r := io.NewSectionReader(h.f, offset, h.idx.size-offset)
+	typ, length, err := ReadHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entry header: %w", err)
+	}
+	if typ != CompressedSignedBeaconBlockType {
+		return nil, fmt.Errorf("expected type %x but got type %x", CompressedSignedBeaconBlockType, typ)
+	}
+
+	sr := snappyPool.Get().(*snappy.Reader)
+	defer snappyPool.Put(sr)
+	sr.Reset(io.LimitReader(r, int64(length)))
+
+	info, err := DecodePartialBlock(sr, fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode partial block: %w", err)
+	}
+	return info, nil
+}
+
+// dataBeforeEOFReader defers io.EOF to the next read when a read returns data.
+// Readers such as HTTP response bodies may return the last data together with io.EOF,
+// which the SSZ decoding reader treats as an error, even if all the data it needed was read.
+type dataBeforeEOFReader struct {
+	r io.Reader
+}
+
+func (r dataBeforeEOFReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 && err == io.EOF {
+		return n, nil
+	}
+	return n, err
+}
+
+// DecodePartialContainer decodes only the selected fields, by field name, of a SSZ encoded container of the given type.
+// The input is streamed: skipped fields are discarded, and only the selected fields are held in memory.
+// Reading stops after the last selected field.
+func DecodePartialContainer(r io.Reader, typ *view.ContainerTypeDef, fields map[string]codec.Deserializable) error {
+	r = dataBeforeEOFReader{r: r}
+	type variableField struct {
+		name   string
+		offset uint64
+	}
+	var variable []variableField
+	// position in the container
+	pos := uint64(0)
+	remaining := len(fields)
+	for _, f := range typ.Fields {
+		if remaining == 0 {
+			return nil
+		}
+		if !f.Type.IsFixedByteLength() {
+			var x [4]byte
+			if _, err := io.ReadFull(r, x[:]); err != nil {
+				return fmt.Errorf("failed to read offset of field %q: %w", f.Name, err)
+			}
+			pos += 4
+			variable = append(variable, variableField{name: f.Name, offset: uint64(binary.LittleEndian.Uint32(x[:]))})
+			continue
+		}
+		size := f.Type.TypeByteLength()
+		if dest, ok := fields[f.Name]; ok {
+			if err := dest.Deserialize(codec.NewDecodingReader(io.LimitReader(r, int64(size)), size)); err != nil {
+				return fmt.Errorf("failed to decode field %q: %w", f.Name, err)
+			}
+			remaining -= 1
+		} else if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return fmt.Errorf("failed to skip field %q: %w", f.Name, err)
+		}
+		pos += size
+	}
+	if pos != typ.FixedPartSize {
+		return fmt.Errorf("unexpected fixed part size %d, expected %d", pos, typ.FixedPartSize)
+	}
+	for i, f := range variable {
+		if remaining == 0 {
+			return nil
+		}
+		if f.offset < pos {
+			return fmt.Errorf("invalid offset %d of field %q, already at %d", f.offset, f.name, pos)
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(f.offset-pos)); err != nil {
+			return fmt.Errorf("failed to skip to field %q: %w", f.name, err)
+		}
+		pos = f.offset
+		dest, ok := fields[f.name]
+		if !ok {
+			continue
+		}
+		if i+1 < len(variable) {
+			end := variable[i+1].offset
+			if end < f.offset {
+				return fmt.Errorf("invalid end offset %d of field %q, starting at %d", end, f.name, f.offset)
+			}
+			size := end - f.offset
+			if err := dest.Deserialize(codec.NewDecodingReader(io.LimitReader(r, int64(size)), size)); err != nil {
+				return fmt.Errorf("failed to decode field %q: %w", f.name, err)
+			}
+			pos = end
+		} else {
+			// the last field spans the remaining input, which has unknown length
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("failed to read field %q: %w", f.name, err)
+			}
+			if err := dest.Deserialize(codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))); err != nil {
+				return fmt.Errorf("failed to decode field %q: %w", f.name, err)
+			}
+		}
+		remaining -= 1
+	}
+	return nil
+}
+
+// PartialState decodes only the selected fields of the state at the given slot, with DecodePartialContainer.
+// The type is the fork-specific beacon state type.
+func (s *Store) PartialState(slot common.Slot, typ *view.ContainerTypeDef, fields map[string]codec.Deserializable) error {
+	if slot%SlotsPerEra != 0 {
+		return fmt.Errorf("can only open states at multiples of era size, but got request for %d", slot)
+	}
+	h, err := s.acquire(slot)
+	if err != nil {
+		return fmt.Errorf("failed to open era: %w", err)
+	}
+	defer s.release(h)
+	r := io.NewSectionReader(h.f, h.idx.state, h.idx.size-h.idx.state)
+	entryType, length, err := ReadHeader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read entry header: %w", err)
+	}
+	if entryType != CompressedBeaconStateType {
+		return fmt.Errorf("expected type %x but got type %x", CompressedBeaconStateType, entryType)
+	}
+
+	sr := snappyPool.Get().(*snappy.Reader)
+	defer snappyPool.Put(sr)
+	sr.Reset(io.LimitReader(r, int64(length)))
+
+	if err := DecodePartialContainer(sr, typ, fields); err != nil {
+		return fmt.Errorf("failed to decode partial state: %w", err)
+	}
+	return nil
+}
@@ -2,11 +2,14 @@ package era
 
 import (
 	"bytes"
+	"container/list"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -15,11 +18,66 @@ import (
 	"github.com/protolambda/ztyp/codec"
 )
 
+// DefaultMaxOpen is the default number of era files a Store keeps open.
+const DefaultMaxOpen = 32
+
+// File is an open era file, readable at arbitrary offsets.
+type File interface {
+	fs.File
+	io.ReaderAt
+}
+
 type Store struct {
-	// era file paths indexed by state starting-slot
-	Files map[common.Slot]string
+	// FS is the file system the era files are read from.
+	FS fs.FS
+	// Dir is the OS directory of FS, if any, used to display era file paths.
+	Dir string
+
+	// MaxOpen is the maximum number of era files to keep open when not in use.
+	MaxOpen int
+
+	// IndexPath is the OS path of the persisted index of the store, if any.
+	// The index is read on Load, and written when Update finds new or changed era files.
+	IndexPath string
+
+	// root is the directory in FS that is indexed
+	root string
+
+	lock sync.Mutex
+	// era file paths in FS, indexed by state starting-slot
+	files map[common.Slot]string
+	// state starting-slot of indexed era file paths
+	paths map[string]common.Slot
+	// problems found while indexing era files
+	diagnostics []Diagnostic
+	// size and modification time of indexed era file paths
+	meta map[string]fileMeta
+	// persisted slot-indices of era files, by path, see LoadIndex
+	cached map[string]*cachedIndex
+	// parsed slot-indices of era files, indexed by state starting-slot
+	indices map[common.Slot]*eraIndex
+	// open era files, indexed by state starting-slot
+	open map[common.Slot]*list.Element
+	// least recently used open era files are at the back
+	lru *list.List
+}
 
-	// TODO cache open era files
+// eraIndex is the parsed slot-index data of an era file.
+type eraIndex struct {
+	size int64
+	// state offset relative to start of file
+	state int64
+	// block offsets relative to start of file, 0 if no block at the slot. Nil for the genesis era.
+	blocks []int64
+}
+
+type eraHandle struct {
+	slot common.Slot
+	f    File
+	idx  *eraIndex
+	// number of users of the handle, the file is only closed when unused.
+	refs    int
+	evicted bool
 }
 
 var (
@@ -30,36 +88,206 @@ var (
 
 func NewStore() *Store {
 	return &Store{
-		Files: make(map[common.Slot]string),
+		files:   make(map[common.Slot]string),
+		paths:   make(map[string]common.Slot),
+		meta:    make(map[string]fileMeta),
+		cached:  make(map[string]*cachedIndex),
+		MaxOpen: DefaultMaxOpen,
+		indices: make(map[common.Slot]*eraIndex),
+		open:    make(map[common.Slot]*list.Element),
+		lru:     list.New(),
 	}
 }
 
+// Load indexes the era files in the given OS directory, see LoadFS.
 func (s *Store) Load(dirPath string) error {
-	return filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
-		if !d.IsDir() && strings.HasSuffix(path, ".era") {
-			f, err := os.Open(path)
+	s.Dir = dirPath
+	return s.LoadFS(os.DirFS(dirPath), ".")
+}
+
+// LoadFS indexes the era files in the given directory of the file system,
+// and reads the era files from the file system from then on.
+// If IndexPath is set, the persisted index is used to skip reading unchanged era files.
+func (s *Store) LoadFS(fsys fs.FS, dir string) error {
+	s.FS = fsys
+	s.root = dir
+	if s.IndexPath != "" {
+		// the index is only a cache: ignore it if it cannot be used
+		_ = s.ReadIndexFile(s.IndexPath)
+	}
+	_, err := s.Update()
+	return err
+}
+
+// Update indexes era files that were added or changed in the store directory since the last Load or Update,
+// and returns the state starting-slots of the added files.
+// Files that are unchanged since they were persisted with SaveIndex are not read.
+// Update is safe to call while the store is in use.
+func (s *Store) Update() (added []common.Slot, err error) {
+	changed := false
+	err = fs.WalkDir(s.FS, s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".era") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat %q: %w", path, err)
+		}
+		meta := fileMeta{size: info.Size(), modTime: info.ModTime().UnixNano()}
+		s.lock.Lock()
+		prev, known := s.meta[path]
+		cached, isCached := s.cached[path]
+		s.lock.Unlock()
+		if known {
+			if prev == meta {
+				return nil
+			}
+			s.remove(path)
+			changed = true
+		}
+		var startSlot common.Slot
+		if isCached && cached.meta == meta {
+			startSlot = cached.slot
+		} else {
+			f, size, err := openFile(s.FS, path)
 			if err != nil {
 				return fmt.Errorf("failed to read %q: %w", path, err)
 			}
 			defer f.Close()
-			end, err := f.Seek(0, io.SeekEnd)
-			if err != nil {
-				return fmt.Errorf("failed to seek era to end: %w", err)
-			}
-			startSlot, err := SeekState(f, end)
-			if err != nil {
-				return fmt.Errorf("failed to seek era to state: %w", err)
+			if s.IndexPath == "" {
+				startSlot, err = SeekState(io.NewSectionReader(f, 0, size), size)
+				if err != nil {
+					return fmt.Errorf("failed to seek era to state: %w", err)
+				}
+			} else {
+				// read the complete slot-indices, to persist them
+				idx, slot, err := readIndex(f, size)
+				if err != nil {
+					return fmt.Errorf("failed to read era %q index: %w", path, err)
+				}
+				startSlot = slot
+				s.lock.Lock()
+				s.cached[path] = newCachedIndex(meta, slot, idx)
+				s.lock.Unlock()
 			}
-			s.Files[startSlot] = path
+			changed = true
+		}
+		if s.add(startSlot, path, meta) {
+			added = append(added, startSlot)
 		}
 		return nil
 	})
+	if err != nil {
+		return added, err
+	}
+	if changed && s.IndexPath != "" {
+		if err := s.WriteIndexFile(s.IndexPath); err != nil {
+			return added, fmt.Errorf("failed to persist era store index: %w", err)
+		}
+	}
+	return added, nil
+}
+
+// remove drops the era file at the given path from the store, to re-index it after it changed.
+func (s *Store) remove(p string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	slot, ok := s.paths[p]
+	if !ok {
+		return
+	}
+	delete(s.paths, p)
+	delete(s.meta, p)
+	if s.files[slot] != p {
+		return
+	}
+	delete(s.files, slot)
+	delete(s.indices, slot)
+	if e, ok := s.open[slot]; ok {
+		h := e.Value.(*eraHandle)
+		s.lru.Remove(e)
+		delete(s.open, slot)
+		h.evicted = true
+		if h.refs == 0 {
+			_ = h.f.Close()
+		}
+	}
+}
+
+// add indexes the era file at the given path, and returns false if there already is an era file with the same state slot.
+// Problems with the file name and duplicate files are recorded as diagnostics.
+func (s *Store) add(slot common.Slot, p string, meta fileMeta) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paths[p] = slot
+	s.meta[p] = meta
+	if _, eraNumber, _, err := ParseFilename(path.Base(p)); err != nil {
+		s.diagnostics = append(s.diagnostics, Diagnostic{Kind: DiagnosticFilename, Slot: slot, Path: p, Msg: err.Error()})
+	} else if eraNumber*SlotsPerEra != uint64(slot) {
+		s.diagnostics = append(s.diagnostics, Diagnostic{Kind: DiagnosticFilename, Slot: slot, Path: p,
+			Msg: fmt.Sprintf("file name has era %d, but state is at slot %d of era %d", eraNumber, slot, slot/SlotsPerEra)})
+	}
+	if prev, ok := s.files[slot]; ok {
+		s.diagnostics = append(s.diagnostics, Diagnostic{Kind: DiagnosticDuplicate, Slot: slot, Path: p,
+			Msg: fmt.Sprintf("state slot %d is already served by %s", slot, prev)})
+		return false
+	}
+	s.files[slot] = p
+	return true
+}
+
+// Slots returns the state starting-slots of all era files, in increasing order.
+func (s *Store) Slots() []common.Slot {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	out := make([]common.Slot, 0, len(s.files))
+	for slot := range s.files {
+		out = append(out, slot)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// openFile opens an era file of the file system, and returns it with its size.
+func openFile(fsys fs.FS, path string) (File, int64, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	rf, ok := f.(File)
+	if !ok {
+		_ = f.Close()
+		return nil, 0, fmt.Errorf("era file %q does not support random access", path)
+	}
+	info, err := rf.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, fmt.Errorf("failed to stat era file: %w", err)
+	}
+	return rf, info.Size(), nil
+}
+
+// Path returns the display path of the era file with the given state slot:
+// the OS path if loaded from an OS directory, the file system path otherwise.
+func (s *Store) Path(slot common.Slot) string {
+	s.lock.Lock()
+	p := s.files[slot]
+	s.lock.Unlock()
+	if s.Dir == "" {
+		return p
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(p))
 }
 
 func (s *Store) Bounds() (min, max common.Slot) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	min = ^common.Slot(0)
 	max = common.Slot(0)
-	for k := range s.Files {
+	for k := range s.files {
 		if k < min {
 			min = k
 		}
@@ -70,41 +298,186 @@ func (s *Store) Bounds() (min, max common.Slot) {
 	return
 }
 
-func (s *Store) openEra(slot common.Slot) (*os.File, error) {
-	p, ok := s.Files[slot]
+// readIndex reads the slot-indices of an era file, and returns them with the state starting-slot.
+func readIndex(f io.ReaderAt, size int64) (*eraIndex, common.Slot, error) {
+	r := io.NewSectionReader(f, 0, size)
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		return nil, 0, fmt.Errorf("failed to seek era to end: %w", err)
+	}
+	state, slot, err := ReadStateOffsetAndSlot(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read state offset: %w", err)
+	}
+	idx := &eraIndex{size: size, state: state}
+	if slot == 0 {
+		return idx, slot, nil
+	}
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		return nil, 0, fmt.Errorf("failed to seek era to end: %w", err)
+	}
+	idx.blocks, err = ReadBlockOffsets(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read block offsets: %w", err)
+	}
+	return idx, slot, nil
+}
+
+// knownIndex returns the slot-index of the era file from memory or the persisted index, or nil if it has to be read.
+// The store must be locked.
+func (s *Store) knownIndex(slot common.Slot, p string) *eraIndex {
+	if idx, ok := s.indices[slot]; ok {
+		return idx
+	}
+	if c, ok := s.cached[p]; ok && c.meta == s.meta[p] && c.slot == slot {
+		idx, err := c.decode()
+		if err == nil {
+			s.indices[slot] = idx
+			return idx
+		}
+		// fall back to reading the index from the file
+		delete(s.cached, p)
+	}
+	return nil
+}
+
+// readEraIndex reads the slot-index of the era file, and checks it is the era with the given state slot.
+func readEraIndex(slot common.Slot, p string, f io.ReaderAt, size int64) (*eraIndex, error) {
+	idx, stateSlot, err := readIndex(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read era %q index: %w", p, err)
+	}
+	if stateSlot != slot {
+		return nil, fmt.Errorf("sanity check of state starting-slot failed: expected %d, got %d", slot, stateSlot)
+	}
+	return idx, nil
+}
+
+// acquire returns an open handle of the era with the given state slot.
+// The handle must be released after use.
+//
+// The era file is opened, and its index read, without holding the store lock,
+// so other eras can be acquired meanwhile, e.g. by parallel workers on a slow file system.
+func (s *Store) acquire(slot common.Slot) (*eraHandle, error) {
+	s.lock.Lock()
+	if h := s.reuse(slot); h != nil {
+		s.lock.Unlock()
+		return h, nil
+	}
+	p, ok := s.files[slot]
+	if !ok {
+		s.lock.Unlock()
+		return nil, fs.ErrNotExist
+	}
+	idx := s.knownIndex(slot, p)
+	s.lock.Unlock()
+
+	f, size, err := openFile(s.FS, p)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil {
+		idx, err = readEraIndex(slot, p, f, size)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+
+	s.lock.Lock()
+	// another caller may have opened the era meanwhile
+	if h := s.reuse(slot); h != nil {
+		s.lock.Unlock()
+		_ = f.Close()
+		return h, nil
+	}
+	if s.files[slot] != p {
+		s.lock.Unlock()
+		_ = f.Close()
+		// the era file changed meanwhile, open it again
+		return s.acquire(slot)
+	}
+	s.indices[slot] = idx
+	h := &eraHandle{slot: slot, f: f, idx: idx, refs: 1}
+	s.open[slot] = s.lru.PushFront(h)
+	s.evict()
+	s.lock.Unlock()
+	return h, nil
+}
+
+// reuse returns the open handle of the era with the given state slot, or nil if it is not open.
+// The store must be locked.
+func (s *Store) reuse(slot common.Slot) *eraHandle {
+	e, ok := s.open[slot]
 	if !ok {
-		return nil, os.ErrNotExist
+		return nil
+	}
+	s.lru.MoveToFront(e)
+	h := e.Value.(*eraHandle)
+	h.refs += 1
+	return h
+}
+
+// evict drops the least recently used handles until there are no more than MaxOpen open handles.
+// Handles that are still in use are closed when released.
+func (s *Store) evict() {
+	for s.lru.Len() > s.MaxOpen {
+		e := s.lru.Back()
+		h := e.Value.(*eraHandle)
+		s.lru.Remove(e)
+		delete(s.open, h.slot)
+		h.evicted = true
+		if h.refs == 0 {
+			_ = h.f.Close()
+		}
+	}
+}
+
+func (s *Store) release(h *eraHandle) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	h.refs -= 1
+	if h.refs == 0 && h.evicted {
+		_ = h.f.Close()
+	}
+}
+
+// Close closes all open era files. Era files that are still in use are closed when released.
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var result error
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		h := e.Value.(*eraHandle)
+		h.evicted = true
+		if h.refs == 0 {
+			if err := h.f.Close(); err != nil {
+				result = err
+			}
+		}
 	}
-	return os.Open(p)
+	s.lru.Init()
+	s.open = make(map[common.Slot]*list.Element)
+	return result
 }
 
 func (s *Store) State(slot common.Slot, dest common.SSZObj) error {
 	if slot%SlotsPerEra != 0 {
 		return fmt.Errorf("can only open states at multiples of era size, but got request for %d", slot)
 	}
-	f, err := s.openEra(slot)
+	h, err := s.acquire(slot)
 	if err != nil {
 		return fmt.Errorf("failed to open era: %w", err)
 	}
-	defer f.Close()
-	end, err := f.Seek(0, io.SeekEnd)
-	if err != nil {
-		return fmt.Errorf("failed to seek era to end: %w", err)
-	}
-	startSlot, err := SeekState(f, end)
-	if err != nil {
-		return fmt.Errorf("failed to seek era to state: %w", err)
-	}
-	if slot != startSlot {
-		return fmt.Errorf("sanity check of state starting-slot slot failed: %w", err)
-	}
+	defer s.release(h)
+	r := io.NewSectionReader(h.f, h.idx.state, h.idx.size-h.idx.state)
+
 	sr := snappyPool.Get().(*snappy.Reader)
 	defer snappyPool.Put(sr)
 
 	buf := stateBufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer stateBufPool.Put(buf)
-	if err := CoppySnappyEntry(f, buf, sr, CompressedBeaconStateType); err != nil {
+	if err := CoppySnappyEntry(r, buf, sr, CompressedBeaconStateType); err != nil {
 		return fmt.Errorf("failed to read compressed beacon state: %w", err)
 	}
 	dr := codec.NewDecodingReader(buf, uint64(buf.Len()))
@@ -116,26 +489,24 @@ func (s *Store) State(slot common.Slot, dest common.SSZObj) error {
 
 func (s *Store) Block(slot common.Slot, dest common.SSZObj) error {
 	eraSlot := slot - (slot % SlotsPerEra) + SlotsPerEra
-	f, err := s.openEra(eraSlot)
+	h, err := s.acquire(eraSlot)
 	if err != nil {
 		return fmt.Errorf("failed to open era: %w", err)
 	}
-	defer f.Close()
-	end, err := f.Seek(0, io.SeekEnd)
-	if err != nil {
-		return fmt.Errorf("failed to seek era to end: %w", err)
-	}
-	err = SeekBlock(f, uint64(slot%SlotsPerEra), end)
-	if err != nil {
-		return fmt.Errorf("failed to seek era to block: %w", err)
+	defer s.release(h)
+	offset := h.idx.blocks[slot%SlotsPerEra]
+	if offset == 0 {
+		return fmt.Errorf("failed to seek era to block: %w", ErrNotExist)
 	}
+	r := io.NewSectionReader(h.f, offset, h.idx.size-offset)
+
 	sr := snappyPool.Get().(*snappy.Reader)
 	defer snappyPool.Put(sr)
 
 	buf := blockBufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer blockBufPool.Put(buf)
-	if err := CoppySnappyEntry(f, buf, sr, CompressedSignedBeaconBlockType); err != nil {
+	if err := CoppySnappyEntry(r, buf, sr, CompressedSignedBeaconBlockType); err != nil {
 		return fmt.Errorf("failed to read compressed signed beacon block: %w", err)
 	}
 	dr := codec.NewDecodingReader(buf, uint64(buf.Len()))
@@ -144,3 +515,14 @@ func (s *Store) Block(slot common.Slot, dest common.SSZObj) error {
 	}
 	return nil
 }
+
+// Offsets returns the file path, the file offsets of the blocks (0 if not present, nil for genesis era),
+// and the file offset of the state, of the era with the given state slot.
+func (s *Store) Offsets(slot common.Slot) (path string, blocks []int64, state int64, err error) {
+	h, err := s.acquire(slot)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("failed to open era: %w", err)
+	}
+	defer s.release(h)
+	return s.Path(slot), h.idx.blocks, h.idx.state, nil
+}
@@ -0,0 +1,204 @@
+package era
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Filename formats the era file name: <config-name>-<era-number>-<short-historical-root>.era
+// The era root is the historical root of the era, or the genesis validators root for era 0.
+func Filename(network string, eraNumber uint64, eraRoot common.Root) string {
+	return fmt.Sprintf("%s-%05d-%x.era", network, eraNumber, eraRoot[:4])
+}
+
+// ParseFilename parses the network, era number and short era root of a
+// <config-name>-<era-number>-<short-historical-root>.era file name, see Filename.
+func ParseFilename(name string) (network string, eraNumber uint64, shortRoot [4]byte, err error) {
+	parts := strings.Split(strings.TrimSuffix(name, ".era"), "-")
+	if len(parts) < 3 {
+		return "", 0, shortRoot, fmt.Errorf("expected <network>-<era>-<shortroot>.era file name, got %q", name)
+	}
+	eraNumber, err = strconv.ParseUint(parts[len(parts)-2], 10, 64)
+	if err != nil {
+		return "", 0, shortRoot, fmt.Errorf("bad era number in file name: %w", err)
+	}
+	rootStr := parts[len(parts)-1]
+	if len(rootStr) != 8 {
+		return "", 0, shortRoot, fmt.Errorf("bad short root length in file name: %q", rootStr)
+	}
+	if _, err := hex.Decode(shortRoot[:], []byte(rootStr)); err != nil {
+		return "", 0, shortRoot, fmt.Errorf("bad short root in file name: %w", err)
+	}
+	return strings.Join(parts[:len(parts)-2], "-"), eraNumber, shortRoot, nil
+}
+
+// EraRoot computes the era root of an era-state, as used in the era file name.
+// The era root of era 0 is the genesis validators root.
+// For later eras it is the historical root, i.e. the hash-tree-root of the historical batch
+// of the state block roots and state roots (equal to the root of the capella historical summary).
+func EraRoot(spec *common.Spec, eraNumber uint64, genesisValidatorsRoot common.Root,
+	blockRoots phase0.HistoricalBatchRoots, stateRoots phase0.HistoricalBatchRoots) common.Root {
+	if eraNumber == 0 {
+		return genesisValidatorsRoot
+	}
+	batch := phase0.HistoricalBatch{BlockRoots: blockRoots, StateRoots: stateRoots}
+	return batch.HashTreeRoot(spec, tree.GetHashFn())
+}
+
+// Writer writes era files: a sequence of groups, each with blocks, an era-state and the slot-indices.
+//
+// For each group: call StartGroup, then WriteBlock for each available block in slot order,
+// and then WriteState to complete the group.
+type Writer struct {
+	w      io.Writer
+	offset int64
+
+	inGroup   bool
+	stateSlot common.Slot
+	// block offsets relative to start of file, 0 if no block at the slot
+	blockOffsets [SlotsPerEra]int64
+	lastBlock    common.Slot
+	hasBlock     bool
+
+	buf bytes.Buffer
+	sw  *snappy.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, sw: snappy.NewBufferedWriter(nil)}
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+func (w *Writer) writeHeader(typ EntryType, length uint32) error {
+	var x [headerSize]byte
+	copy(x[0:2], typ[:])
+	binary.LittleEndian.PutUint32(x[2:6], length)
+	return w.write(x[:])
+}
+
+func (w *Writer) writeEntry(typ EntryType, data []byte) error {
+	if uint64(len(data)) > uint64(^uint32(0)) {
+		return fmt.Errorf("entry too large: %d bytes", len(data))
+	}
+	if err := w.writeHeader(typ, uint32(len(data))); err != nil {
+		return fmt.Errorf("failed to write entry header: %w", err)
+	}
+	if err := w.write(data); err != nil {
+		return fmt.Errorf("failed to write entry data: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) writeSnappyEntry(typ EntryType, obj common.SSZObj) error {
+	w.buf.Reset()
+	w.sw.Reset(&w.buf)
+	if err := obj.Serialize(codec.NewEncodingWriter(w.sw)); err != nil {
+		return fmt.Errorf("failed to serialize: %w", err)
+	}
+	if err := w.sw.Close(); err != nil {
+		return fmt.Errorf("failed to flush snappy output: %w", err)
+	}
+	return w.writeEntry(typ, w.buf.Bytes())
+}
+
+func (w *Writer) writeSlotIndex(startSlot common.Slot, offsets []int64) error {
+	data := make([]byte, 8+8*len(offsets)+8)
+	binary.LittleEndian.PutUint64(data[0:8], uint64(startSlot))
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint64(data[8+8*i:8+8*i+8], uint64(offset))
+	}
+	binary.LittleEndian.PutUint64(data[len(data)-8:], uint64(len(offsets)))
+	return w.writeEntry(SlotIndexType, data)
+}
+
+// StartGroup starts a new group, by writing the version entry.
+// The era-state of the group is at stateSlot, which must be a multiple of SlotsPerEra.
+// The blocks of the group are those of the era before the state slot, if any.
+func (w *Writer) StartGroup(stateSlot common.Slot) error {
+	if w.inGroup {
+		return fmt.Errorf("previous group with state at slot %d is not finished", w.stateSlot)
+	}
+	if stateSlot%SlotsPerEra != 0 {
+		return fmt.Errorf("state slot %d is not a multiple of era size", stateSlot)
+	}
+	if err := w.writeHeader(VersionType, 0); err != nil {
+		return fmt.Errorf("failed to write version entry: %w", err)
+	}
+	w.inGroup = true
+	w.stateSlot = stateSlot
+	w.blockOffsets = [SlotsPerEra]int64{}
+	w.hasBlock = false
+	return nil
+}
+
+// WriteBlock writes a snappy-compressed signed beacon block of the current group.
+// Blocks must be written in increasing slot order, slots without blocks are skipped.
+func (w *Writer) WriteBlock(slot common.Slot, block common.SSZObj) error {
+	if !w.inGroup {
+		return fmt.Errorf("no group started, cannot write block %d", slot)
+	}
+	if w.stateSlot == 0 {
+		return fmt.Errorf("genesis group has no blocks, cannot write block %d", slot)
+	}
+	if slot+SlotsPerEra < w.stateSlot || slot >= w.stateSlot {
+		return fmt.Errorf("block slot %d is outside of era range of state slot %d", slot, w.stateSlot)
+	}
+	if w.hasBlock && slot <= w.lastBlock {
+		return fmt.Errorf("block slot %d is not after previous block slot %d", slot, w.lastBlock)
+	}
+	offset := w.offset
+	if err := w.writeSnappyEntry(CompressedSignedBeaconBlockType, block); err != nil {
+		return fmt.Errorf("failed to write block %d: %w", slot, err)
+	}
+	w.blockOffsets[slot%SlotsPerEra] = offset
+	w.lastBlock = slot
+	w.hasBlock = true
+	return nil
+}
+
+// WriteState writes the snappy-compressed era-state, followed by the block slot-index (if not genesis)
+// and the state slot-index, completing the current group.
+func (w *Writer) WriteState(state common.SSZObj) error {
+	if !w.inGroup {
+		return fmt.Errorf("no group started, cannot write state")
+	}
+	stateOffset := w.offset
+	if err := w.writeSnappyEntry(CompressedBeaconStateType, state); err != nil {
+		return fmt.Errorf("failed to write state %d: %w", w.stateSlot, err)
+	}
+	if w.stateSlot != 0 {
+		// offsets are relative to the start of the slot-index entry
+		indexOffset := w.offset
+		offsets := make([]int64, SlotsPerEra)
+		for i, offset := range w.blockOffsets {
+			if offset != 0 {
+				offsets[i] = offset - indexOffset
+			}
+		}
+		if err := w.writeSlotIndex(w.stateSlot-SlotsPerEra, offsets); err != nil {
+			return fmt.Errorf("failed to write block slot-index: %w", err)
+		}
+	}
+	indexOffset := w.offset
+	if err := w.writeSlotIndex(w.stateSlot, []int64{stateOffset - indexOffset}); err != nil {
+		return fmt.Errorf("failed to write state slot-index: %w", err)
+	}
+	w.inGroup = false
+	return nil
+}
@@ -0,0 +1,319 @@
+package fun
+
+import (
+	"fmt"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/electra"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+// BlockData is the fork-agnostic subset of a signed beacon block that the pipeline uses.
+type BlockData struct {
+	Slot          common.Slot
+	ProposerIndex common.ValidatorIndex
+	Graffiti      common.Root
+	// Attestations in the Electra (EIP-7549) layout
+	Attestations electra.Attestations
+	// SyncAggregate is nil before Altair
+	SyncAggregate *altair.SyncAggregate
+	// ProposerSlashings and AttesterSlashings included in the block,
+	// attester slashings in the Electra (EIP-7549) layout
+	ProposerSlashings phase0.ProposerSlashings
+	AttesterSlashings electra.AttesterSlashings
+}
+
+// StateData is the fork-agnostic subset of a beacon state that the pipeline uses.
+type StateData struct {
+	Slot                  common.Slot
+	GenesisValidatorsRoot common.Root
+	BlockRoots            phase0.HistoricalBatchRoots
+	StateRoots            phase0.HistoricalBatchRoots
+	RandaoMixes           phase0.RandaoMixes
+	Validators            phase0.ValidatorRegistry
+	// HistoricalAccumulator is the historical_roots list,
+	// followed by the roots of the historical_summaries list since Capella.
+	// Entry i is the root of era i+1.
+	HistoricalAccumulator []common.Root
+	// CurrentSyncCommittee and NextSyncCommittee are nil before Altair
+	CurrentSyncCommittee *common.SyncCommittee
+	NextSyncCommittee    *common.SyncCommittee
+	// PreviousEpochParticipation and CurrentEpochParticipation are empty before Altair
+	PreviousEpochParticipation altair.ParticipationRegistry
+	CurrentEpochParticipation  altair.ParticipationRegistry
+}
+
+func historicalAccumulator(roots phase0.HistoricalRoots, summaries capella.HistoricalSummaries) []common.Root {
+	out := make([]common.Root, 0, len(roots)+len(summaries))
+	out = append(out, roots...)
+	hFn := tree.GetHashFn()
+	for i := range summaries {
+		out = append(out, summaries[i].HashTreeRoot(hFn))
+	}
+	return out
+}
+
+// Fork describes how to decode the blocks and states of a single fork.
+type Fork struct {
+	Name string
+	// SyncCommittees is true if the blocks of the fork have a sync aggregate,
+	// and the states have the current and next sync committees.
+	SyncCommittees bool
+	// Epoch returns the activation epoch of the fork
+	Epoch func(spec *common.Spec) common.Epoch
+	// Attestations returns a destination to decode the block attestations list of the fork into,
+	// and a function to get the decoded attestations in the Electra layout.
+	Attestations func(spec *common.Spec) (common.SSZObj, func() electra.Attestations)
+	// AttesterSlashings returns a destination to decode the block attester slashings list of the fork into,
+	// and a function to get the decoded attester slashings in the Electra layout.
+	AttesterSlashings func(spec *common.Spec) (common.SSZObj, func() electra.AttesterSlashings)
+	// BlockRoot decodes the block at the given slot, and returns the slot and hash-tree-root of the block message
+	BlockRoot func(spec *common.Spec, blockFn BlockLookup, slot common.Slot) (common.Slot, common.Root, error)
+	// StateType returns the SSZ type of the beacon state, for partial state decoding
+	StateType func(spec *common.Spec) *view.ContainerTypeDef
+}
+
+// Forks is the registry of supported forks, ordered by activation epoch.
+// Adding support for a new fork means appending an entry here.
+var Forks = []*Fork{
+	{
+		Name:  "phase0",
+		Epoch: func(spec *common.Spec) common.Epoch { return 0 },
+		Attestations: func(spec *common.Spec) (common.SSZObj, func() electra.Attestations) {
+			var atts phase0.Attestations
+			return spec.Wrap(&atts), func() electra.Attestations { return toElectraAttestations(spec, atts) }
+		},
+		AttesterSlashings: func(spec *common.Spec) (common.SSZObj, func() electra.AttesterSlashings) {
+			var slashings phase0.AttesterSlashings
+			return spec.Wrap(&slashings), func() electra.AttesterSlashings { return toElectraAttesterSlashings(slashings) }
+		},
+		BlockRoot: func(spec *common.Spec, blockFn BlockLookup, slot common.Slot) (common.Slot, common.Root, error) {
+			var block phase0.SignedBeaconBlock
+			if err := blockFn(slot, spec.Wrap(&block)); err != nil {
+				return 0, common.Root{}, err
+			}
+			return block.Message.Slot, block.Message.HashTreeRoot(spec, tree.GetHashFn()), nil
+		},
+		StateType: phase0.BeaconStateType,
+	},
+	{
+		Name:           "altair",
+		SyncCommittees: true,
+		Epoch:          func(spec *common.Spec) common.Epoch { return spec.ALTAIR_FORK_EPOCH },
+		Attestations: func(spec *common.Spec) (common.SSZObj, func() electra.Attestations) {
+			var atts phase0.Attestations
+			return spec.Wrap(&atts), func() electra.Attestations { return toElectraAttestations(spec, atts) }
+		},
+		AttesterSlashings: func(spec *common.Spec) (common.SSZObj, func() electra.AttesterSlashings) {
+			var slashings phase0.AttesterSlashings
+			return spec.Wrap(&slashings), func() electra.AttesterSlashings { return toElectraAttesterSlashings(slashings) }
+		},
+		BlockRoot: func(spec *common.Spec, blockFn BlockLookup, slot common.Slot) (common.Slot, common.Root, error) {
+			var block altair.SignedBeaconBlock
+			if err := blockFn(slot, spec.Wrap(&block)); err != nil {
+				return 0, common.Root{}, err
+			}
+			return block.Message.Slot, block.Message.HashTreeRoot(spec, tree.GetHashFn()), nil
+		},
+		StateType: altair.BeaconStateType,
+	},
+	{
+		Name:           "bellatrix",
+		SyncCommittees: true,
+		Epoch:          func(spec *common.Spec) common.Epoch { return spec.BELLATRIX_FORK_EPOCH },
+		Attestations: func(spec *common.Spec) (common.SSZObj, func() electra.Attestations) {
+			var atts phase0.Attestations
+			return spec.Wrap(&atts), func() electra.Attestations { return toElectraAttestations(spec, atts) }
+		},
+		AttesterSlashings: func(spec *common.Spec) (common.SSZObj, func() electra.AttesterSlashings) {
+			var slashings phase0.AttesterSlashings
+			return spec.Wrap(&slashings), func() electra.AttesterSlashings { return toElectraAttesterSlashings(slashings) }
+		},
+		BlockRoot: func(spec *common.Spec, blockFn BlockLookup, slot common.Slot) (common.Slot, common.Root, error) {
+			var block bellatrix.SignedBeaconBlock
+			if err := blockFn(slot, spec.Wrap(&block)); err != nil {
+				return 0, common.Root{}, err
+			}
+			return block.Message.Slot, block.Message.HashTreeRoot(spec, tree.GetHashFn()), nil
+		},
+		StateType: bellatrix.BeaconStateType,
+	},
+	{
+		Name:           "capella",
+		SyncCommittees: true,
+		Epoch:          func(spec *common.Spec) common.Epoch { return spec.CAPELLA_FORK_EPOCH },
+		Attestations: func(spec *common.Spec) (common.SSZObj, func() electra.Attestations) {
+			var atts phase0.Attestations
+			return spec.Wrap(&atts), func() electra.Attestations { return toElectraAttestations(spec, atts) }
+		},
+		AttesterSlashings: func(spec *common.Spec) (common.SSZObj, func() electra.AttesterSlashings) {
+			var slashings phase0.AttesterSlashings
+			return spec.Wrap(&slashings), func() electra.AttesterSlashings { return toElectraAttesterSlashings(slashings) }
+		},
+		BlockRoot: func(spec *common.Spec, blockFn BlockLookup, slot common.Slot) (common.Slot, common.Root, error) {
+			var block capella.SignedBeaconBlock
+			if err := blockFn(slot, spec.Wrap(&block)); err != nil {
+				return 0, common.Root{}, err
+			}
+			return block.Message.Slot, block.Message.HashTreeRoot(spec, tree.GetHashFn()), nil
+		},
+		StateType: capella.BeaconStateType,
+	},
+	{
+		Name:           "deneb",
+		SyncCommittees: true,
+		Epoch:          func(spec *common.Spec) common.Epoch { return spec.DENEB_FORK_EPOCH },
+		Attestations: func(spec *common.Spec) (common.SSZObj, func() electra.Attestations) {
+			var atts phase0.Attestations
+			return spec.Wrap(&atts), func() electra.Attestations { return toElectraAttestations(spec, atts) }
+		},
+		AttesterSlashings: func(spec *common.Spec) (common.SSZObj, func() electra.AttesterSlashings) {
+			var slashings phase0.AttesterSlashings
+			return spec.Wrap(&slashings), func() electra.AttesterSlashings { return toElectraAttesterSlashings(slashings) }
+		},
+		BlockRoot: func(spec *common.Spec, blockFn BlockLookup, slot common.Slot) (common.Slot, common.Root, error) {
+			var block deneb.SignedBeaconBlock
+			if err := blockFn(slot, spec.Wrap(&block)); err != nil {
+				return 0, common.Root{}, err
+			}
+			return block.Message.Slot, block.Message.HashTreeRoot(spec, tree.GetHashFn()), nil
+		},
+		StateType: deneb.BeaconStateType,
+	},
+	{
+		Name:           "electra",
+		SyncCommittees: true,
+		Epoch:          func(spec *common.Spec) common.Epoch { return spec.ELECTRA_FORK_EPOCH },
+		Attestations: func(spec *common.Spec) (common.SSZObj, func() electra.Attestations) {
+			var atts electra.Attestations
+			return spec.Wrap(&atts), func() electra.Attestations { return atts }
+		},
+		AttesterSlashings: func(spec *common.Spec) (common.SSZObj, func() electra.AttesterSlashings) {
+			var slashings electra.AttesterSlashings
+			return spec.Wrap(&slashings), func() electra.AttesterSlashings { return slashings }
+		},
+		BlockRoot: func(spec *common.Spec, blockFn BlockLookup, slot common.Slot) (common.Slot, common.Root, error) {
+			var block electra.SignedBeaconBlock
+			if err := blockFn(slot, spec.Wrap(&block)); err != nil {
+				return 0, common.Root{}, err
+			}
+			return block.Message.Slot, block.Message.HashTreeRoot(spec, tree.GetHashFn()), nil
+		},
+		StateType: electra.BeaconStateType,
+	},
+}
+
+// ForkAt returns the latest registered fork that is active at the given epoch.
+func ForkAt(spec *common.Spec, epoch common.Epoch) *Fork {
+	out := Forks[0]
+	for _, f := range Forks[1:] {
+		if epoch < f.Epoch(spec) {
+			break
+		}
+		out = f
+	}
+	return out
+}
+
+// DecodePartialBlock decodes the slot, proposer index, graffiti, sync aggregate, slashings and attestations
+// of the block at the given slot, with the fork of the slot, skipping the rest of the block.
+func DecodePartialBlock(spec *common.Spec, blockFn PartialBlockLookup, slot common.Slot) (*BlockData, error) {
+	f := ForkAt(spec, spec.SlotToEpoch(slot))
+	dest, atts := f.Attestations(spec)
+	attSlashingsDest, attSlashings := f.AttesterSlashings(spec)
+	var proposerSlashings phase0.ProposerSlashings
+	fields := &era.BlockFields{
+		ProposerSlashings: spec.Wrap(&proposerSlashings),
+		AttesterSlashings: attSlashingsDest,
+		Attestations:      dest,
+	}
+	var syncAggregate *altair.SyncAggregate
+	if f.SyncCommittees {
+		syncAggregate = new(altair.SyncAggregate)
+		fields.SyncAggregate = spec.Wrap(syncAggregate)
+	}
+	info, err := blockFn(slot, fields)
+	if err != nil {
+		return nil, err
+	}
+	if slot != info.Slot {
+		return nil, fmt.Errorf("loaded wrong %s block, got slot %d, but requested %d", f.Name, info.Slot, slot)
+	}
+	return &BlockData{
+		Slot:              info.Slot,
+		ProposerIndex:     info.ProposerIndex,
+		Graffiti:          info.Graffiti,
+		Attestations:      atts(),
+		SyncAggregate:     syncAggregate,
+		ProposerSlashings: proposerSlashings,
+		AttesterSlashings: attSlashings(),
+	}, nil
+}
+
+// DecodePartialState decodes only the given fields of the state at the given slot, with the fork of the slot.
+// Fields are named as in the SSZ beacon state type, e.g. "block_roots".
+// The "historical_roots" and "historical_summaries" fields are decoded into the HistoricalAccumulator.
+// Fields that are not present in the fork of the state are left empty.
+func DecodePartialState(spec *common.Spec, stateFn PartialStateLookup, slot common.Slot, fields ...string) (*StateData, error) {
+	f := ForkAt(spec, spec.SlotToEpoch(slot))
+	typ := f.StateType(spec)
+	present := make(map[string]struct{}, len(typ.Fields))
+	for _, field := range typ.Fields {
+		present[field.Name] = struct{}{}
+	}
+	out := new(StateData)
+	var historicalRoots phase0.HistoricalRoots
+	var historicalSummaries capella.HistoricalSummaries
+	dests := make(map[string]codec.Deserializable, len(fields))
+	for _, name := range fields {
+		if _, ok := present[name]; !ok {
+			continue
+		}
+		switch name {
+		case "slot":
+			dests[name] = &out.Slot
+		case "genesis_validators_root":
+			dests[name] = &out.GenesisValidatorsRoot
+		case "block_roots":
+			dests[name] = spec.Wrap(&out.BlockRoots)
+		case "state_roots":
+			dests[name] = spec.Wrap(&out.StateRoots)
+		case "randao_mixes":
+			dests[name] = spec.Wrap(&out.RandaoMixes)
+		case "validators":
+			dests[name] = spec.Wrap(&out.Validators)
+		case "historical_roots":
+			dests[name] = spec.Wrap(&historicalRoots)
+		case "historical_summaries":
+			dests[name] = spec.Wrap(&historicalSummaries)
+		case "current_sync_committee":
+			out.CurrentSyncCommittee = new(common.SyncCommittee)
+			dests[name] = spec.Wrap(out.CurrentSyncCommittee)
+		case "next_sync_committee":
+			out.NextSyncCommittee = new(common.SyncCommittee)
+			dests[name] = spec.Wrap(out.NextSyncCommittee)
+		case "previous_epoch_participation":
+			dests[name] = spec.Wrap(&out.PreviousEpochParticipation)
+		case "current_epoch_participation":
+			dests[name] = spec.Wrap(&out.CurrentEpochParticipation)
+		default:
+			return nil, fmt.Errorf("unsupported state field %q", name)
+		}
+	}
+	if err := stateFn(slot, typ, dests); err != nil {
+		return nil, fmt.Errorf("failed to decode partial %s state at slot %d: %w", f.Name, slot, err)
+	}
+	if _, ok := dests["historical_roots"]; ok {
+		out.HistoricalAccumulator = historicalAccumulator(historicalRoots, historicalSummaries)
+	}
+	return out, nil
+}
@@ -31,9 +31,15 @@ type Backend interface {
 	HandleImageRequest()
 }
 
-func StartHttpServer(log log.Logger, listenAddr string, indexData *IndexData, handleImgRequest func(tileType uint8) http.Handler) *http.Server {
+func StartHttpServer(log log.Logger, listenAddr string, indexData *IndexData, handleImgRequest func(tileType uint8) http.Handler,
+	handleFinalized http.Handler, handleIncidents http.Handler) *http.Server {
 	var mux http.ServeMux
-	mux.Handle("/validator-order", http.StripPrefix("/validator-order", handleImgRequest(0)))
+	for _, layer := range tileLayers {
+		route := "/" + layer.name
+		mux.Handle(route, http.StripPrefix(route, handleImgRequest(layer.tileType)))
+	}
+	mux.Handle("/finalized", handleFinalized)
+	mux.Handle("/incidents/list", handleIncidents)
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		err := indexTempl.Execute(w, indexData)
 		if err != nil {
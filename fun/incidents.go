@@ -0,0 +1,461 @@
+package fun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// KeyIncidents is a:
+	// 3 byte prefix for per-epoch slashing incident keying, followed by:
+	// 8 byte big-endian epoch value.
+	//
+	// The epoch key is the same as for KeyPerf: incidents of epoch e are the slashings included in the blocks of epoch e,
+	// and the conflicting votes of epoch e-1 that were included in the blocks of epochs e-1 and e.
+	//
+	// Values under this key are snappy block-compressed.
+	//
+	// The value is a []Incident, see encodeIncidents.
+	//
+	// Incidents are stored in the perf DB, and copied to the tiles DB with the incidents tiles, to serve them.
+	KeyIncidents string = "inc"
+)
+
+type IncidentKind uint8
+
+const (
+	// ProposerSlashingIncident is a proposer slashing included in a block.
+	ProposerSlashingIncident IncidentKind = 1
+	// AttesterSlashingIncident is an attester slashing included in a block.
+	AttesterSlashingIncident IncidentKind = 2
+	// DoubleVoteIncident is a pair of included attestations of the same validators,
+	// with the same target epoch but different attestation data. They may not have been slashed.
+	DoubleVoteIncident IncidentKind = 3
+	// SurroundVoteIncident is a pair of included attestations of the same validators,
+	// where the second vote surrounds the first vote. They may not have been slashed.
+	// Only votes within a window of target epochs are compared, see voteTracker.
+	SurroundVoteIncident IncidentKind = 4
+)
+
+func (k IncidentKind) String() string {
+	switch k {
+	case ProposerSlashingIncident:
+		return "proposer_slashing"
+	case AttesterSlashingIncident:
+		return "attester_slashing"
+	case DoubleVoteIncident:
+		return "double_vote"
+	case SurroundVoteIncident:
+		return "surround_vote"
+	default:
+		return fmt.Sprintf("unknown_%d", uint8(k))
+	}
+}
+
+func (k IncidentKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Slashed is true if the incident is a slashing included on-chain.
+func (k IncidentKind) Slashed() bool {
+	return k == ProposerSlashingIncident || k == AttesterSlashingIncident
+}
+
+// Incident is a slashing included on-chain, or a pair of conflicting votes of the same validators.
+type Incident struct {
+	Kind IncidentKind `json:"kind"`
+	// Slot of the block that included the slashing, or the later of the two conflicting votes.
+	Slot common.Slot `json:"slot"`
+	// Validators that are slashed, or that cast the conflicting votes, ordered by index.
+	Validators []common.ValidatorIndex `json:"validators"`
+	// ProposalSlot is the slot of the two conflicting block headers of a proposer slashing.
+	ProposalSlot common.Slot `json:"proposal_slot,omitempty"`
+	// Vote1 and Vote2 are the conflicting attestation data of attester slashings and conflicting votes.
+	Vote1 *phase0.AttestationData `json:"vote_1,omitempty"`
+	Vote2 *phase0.AttestationData `json:"vote_2,omitempty"`
+}
+
+const attestationDataSize = 8 + 8 + 32 + (8 + 32) + (8 + 32)
+
+// encodeIncidents encodes each incident as 1 byte kind, 8 byte little-endian slot,
+// 4 byte little-endian validator count and 4 byte little-endian validator indices,
+// followed by the 8 byte little-endian proposal slot for proposer slashings,
+// or the two SSZ encoded attestation data otherwise.
+func encodeIncidents(incidents []Incident) ([]byte, error) {
+	var buf bytes.Buffer
+	w := codec.NewEncodingWriter(&buf)
+	var tmp [8]byte
+	for i := range incidents {
+		inc := &incidents[i]
+		buf.WriteByte(byte(inc.Kind))
+		binary.LittleEndian.PutUint64(tmp[:], uint64(inc.Slot))
+		buf.Write(tmp[:8])
+		binary.LittleEndian.PutUint32(tmp[:4], uint32(len(inc.Validators)))
+		buf.Write(tmp[:4])
+		for _, vi := range inc.Validators {
+			binary.LittleEndian.PutUint32(tmp[:4], uint32(vi))
+			buf.Write(tmp[:4])
+		}
+		if inc.Kind == ProposerSlashingIncident {
+			binary.LittleEndian.PutUint64(tmp[:], uint64(inc.ProposalSlot))
+			buf.Write(tmp[:8])
+			continue
+		}
+		if inc.Vote1 == nil || inc.Vote2 == nil {
+			return nil, fmt.Errorf("incident %d of kind %s has no votes", i, inc.Kind)
+		}
+		if err := inc.Vote1.Serialize(w); err != nil {
+			return nil, err
+		}
+		if err := inc.Vote2.Serialize(w); err != nil {
+			return nil, err
+		}
+	}
+	return snappy.Encode(nil, buf.Bytes()), nil
+}
+
+func decodeIncidents(data []byte) ([]Incident, error) {
+	data, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress incidents: %w", err)
+	}
+	var out []Incident
+	for len(data) > 0 {
+		if len(data) < 1+8+4 {
+			return nil, fmt.Errorf("incident %d is too short: %d bytes", len(out), len(data))
+		}
+		inc := Incident{
+			Kind: IncidentKind(data[0]),
+			Slot: common.Slot(binary.LittleEndian.Uint64(data[1:9])),
+		}
+		count := uint64(binary.LittleEndian.Uint32(data[9:13]))
+		data = data[13:]
+		if uint64(len(data)) < count*4 {
+			return nil, fmt.Errorf("incident %d has %d validators, but only %d bytes", len(out), count, len(data))
+		}
+		inc.Validators = make([]common.ValidatorIndex, count)
+		for i := range inc.Validators {
+			inc.Validators[i] = common.ValidatorIndex(binary.LittleEndian.Uint32(data[i*4 : i*4+4]))
+		}
+		data = data[count*4:]
+		if inc.Kind == ProposerSlashingIncident {
+			if len(data) < 8 {
+				return nil, fmt.Errorf("incident %d has no proposal slot", len(out))
+			}
+			inc.ProposalSlot = common.Slot(binary.LittleEndian.Uint64(data[:8]))
+			data = data[8:]
+		} else {
+			if len(data) < 2*attestationDataSize {
+				return nil, fmt.Errorf("incident %d has no votes", len(out))
+			}
+			inc.Vote1, inc.Vote2 = new(phase0.AttestationData), new(phase0.AttestationData)
+			for _, v := range []*phase0.AttestationData{inc.Vote1, inc.Vote2} {
+				if err := v.Deserialize(codec.NewDecodingReader(bytes.NewReader(data[:attestationDataSize]), attestationDataSize)); err != nil {
+					return nil, fmt.Errorf("failed to decode vote of incident %d: %w", len(out), err)
+				}
+				data = data[attestationDataSize:]
+			}
+		}
+		out = append(out, inc)
+	}
+	return out, nil
+}
+
+func incidentsKey(epoch common.Epoch) []byte {
+	var key [3 + 8]byte
+	copy(key[:3], KeyIncidents)
+	binary.BigEndian.PutUint64(key[3:], uint64(epoch))
+	return key[:]
+}
+
+// GetIncidents returns the incidents of the given epoch, see KeyIncidents. The db is either the perf DB or the tiles DB.
+func GetIncidents(db *leveldb.DB, epoch common.Epoch) ([]Incident, error) {
+	data, err := db.Get(incidentsKey(epoch), nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeIncidents(data)
+}
+
+// processSlashings collects the proposer and attester slashings included in the blocks of the given epoch.
+func processSlashings(spec *common.Spec, blockFn BlockDataLookup, epoch common.Epoch) ([]Incident, error) {
+	start, err := spec.EpochStartSlot(epoch)
+	if err != nil {
+		return nil, fmt.Errorf("bad epoch start slot of epoch %d: %w", epoch, err)
+	}
+	var out []Incident
+	for slot := start; slot < start+spec.SLOTS_PER_EPOCH; slot++ {
+		if slot == 0 {
+			continue
+		}
+		block, err := blockFn(slot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block at slot %d: %w", slot, err)
+		}
+		if block == nil {
+			continue
+		}
+		for _, ps := range block.ProposerSlashings {
+			out = append(out, Incident{
+				Kind:         ProposerSlashingIncident,
+				Slot:         slot,
+				Validators:   []common.ValidatorIndex{ps.SignedHeader1.Message.ProposerIndex},
+				ProposalSlot: ps.SignedHeader1.Message.Slot,
+			})
+		}
+		for i := range block.AttesterSlashings {
+			as := &block.AttesterSlashings[i]
+			// only the validators in both attestations are slashed
+			first := make(map[common.ValidatorIndex]struct{}, len(as.Attestation1.AttestingIndices))
+			for _, vi := range as.Attestation1.AttestingIndices {
+				first[vi] = struct{}{}
+			}
+			var slashed []common.ValidatorIndex
+			for _, vi := range as.Attestation2.AttestingIndices {
+				if _, ok := first[vi]; ok {
+					slashed = append(slashed, vi)
+				}
+			}
+			sort.Slice(slashed, func(i, j int) bool { return slashed[i] < slashed[j] })
+			vote1, vote2 := as.Attestation1.Data, as.Attestation2.Data
+			out = append(out, Incident{
+				Kind:       AttesterSlashingIncident,
+				Slot:       slot,
+				Validators: slashed,
+				Vote1:      &vote1,
+				Vote2:      &vote2,
+			})
+		}
+	}
+	return out, nil
+}
+
+// voteTracker detects conflicting votes among the included attestations, one target epoch at a time.
+// Votes are compared with the other votes of the same target epoch, for double votes.
+// For surround votes, every vote is compared with the earlier vote of the validator with the highest source epoch,
+// of the target epochs within the window. Target epochs are processed in increasing order,
+// so a vote can only surround an earlier vote, and not be surrounded by one.
+// When the earlier vote with the highest source epoch leaves the window, the other earlier votes are not compared anymore.
+type voteTracker struct {
+	// window is the max number of target epochs between two votes that are compared for surround votes
+	window  common.Epoch
+	started bool
+	target  common.Epoch
+	// distinct attestation data of the target epoch, and the slot where each was first included
+	data  []phase0.AttestationData
+	slots []common.Slot
+	roots map[common.Root]uint32
+	// per validator index: index+1 into data, or 0 if no vote was seen
+	votes []uint32
+
+	// per validator index: index+1 into refData of the earlier vote with the highest source epoch, or 0 if none
+	refs    []uint32
+	refData []phase0.AttestationData
+
+	// conflicts by kind and pair of votes, each with the validators that cast both
+	conflicts map[voteConflict]*Incident
+	order     []voteConflict
+}
+
+type voteConflict struct {
+	kind IncidentKind
+	// first is an index into the data of the current target epoch, or into the earlier votes for surround votes
+	first uint32
+	// second is an index into the data of the current target epoch
+	second uint32
+}
+
+// surroundVoteWindow is the number of target epochs that votes are compared over for surround votes:
+// MIN_VALIDATOR_WITHDRAWABILITY_DELAY, the minimum weak subjectivity period.
+func surroundVoteWindow(spec *common.Spec) common.Epoch {
+	return spec.MIN_VALIDATOR_WITHDRAWABILITY_DELAY
+}
+
+func newVoteTracker(window common.Epoch) *voteTracker {
+	return &voteTracker{window: window, conflicts: make(map[voteConflict]*Incident)}
+}
+
+// reset forgets all votes.
+func (t *voteTracker) reset() {
+	t.started = false
+	t.data, t.slots, t.roots, t.votes = nil, nil, nil, nil
+	t.refs, t.refData = nil, nil
+	t.conflicts = make(map[voteConflict]*Incident)
+	t.order = nil
+}
+
+// follows returns true if the tracker has the votes of the target epoch before the given target epoch.
+func (t *voteTracker) follows(target common.Epoch) bool {
+	return t.started && t.target+1 == target
+}
+
+// start resets the tracker for the votes of the given target epoch.
+// The votes of earlier target epochs are kept if the given target epoch is later.
+func (t *voteTracker) start(target common.Epoch) {
+	if t.started && target > t.target {
+		t.keep(target)
+	} else {
+		t.refs, t.refData = nil, nil
+	}
+	t.started = true
+	t.target = target
+	t.data = nil
+	t.slots = nil
+	t.roots = make(map[common.Root]uint32)
+	t.votes = make([]uint32, len(t.refs))
+}
+
+// keep merges the votes of the current target epoch into the earlier votes,
+// and drops the earlier votes that are out of the window of the given next target epoch.
+func (t *voteTracker) keep(target common.Epoch) {
+	if len(t.votes) > len(t.refs) {
+		t.refs = append(t.refs, make([]uint32, len(t.votes)-len(t.refs))...)
+	}
+	// the kept votes are copied, to drop the attestation data that is not referenced anymore
+	var refData []phase0.AttestationData
+	moved := make(map[*phase0.AttestationData]uint32)
+	for vi, r := range t.refs {
+		var ref *phase0.AttestationData
+		if r != 0 {
+			ref = &t.refData[r-1]
+		}
+		if vi < len(t.votes) {
+			if v := t.votes[vi]; v != 0 && (ref == nil || t.data[v-1].Source.Epoch >= ref.Source.Epoch) {
+				ref = &t.data[v-1]
+			}
+		}
+		if ref == nil || ref.Target.Epoch+t.window < target {
+			t.refs[vi] = 0
+			continue
+		}
+		n, ok := moved[ref]
+		if !ok {
+			refData = append(refData, *ref)
+			n = uint32(len(refData))
+			moved[ref] = n
+		}
+		t.refs[vi] = n
+	}
+	t.refData = refData
+}
+
+// add tracks the vote of a validator, with the given attestation data of the target epoch, included at the given slot.
+func (t *voteTracker) add(vi common.ValidatorIndex, data *phase0.AttestationData, root common.Root, slot common.Slot) {
+	i, ok := t.roots[root]
+	if !ok {
+		t.data = append(t.data, *data)
+		t.slots = append(t.slots, slot)
+		i = uint32(len(t.data))
+		t.roots[root] = i
+	}
+	if uint64(vi) >= uint64(len(t.votes)) {
+		t.votes = append(t.votes, make([]uint32, uint64(vi)+1-uint64(len(t.votes)))...)
+	}
+	prev := t.votes[vi]
+	if prev == i {
+		return
+	}
+	if prev != 0 {
+		// group the validators that cast the same pair of votes, regardless of which was included first
+		first, second := prev, i
+		if first > second {
+			first, second = second, first
+		}
+		t.conflict(voteConflict{kind: DoubleVoteIncident, first: first, second: second}, vi, slot)
+	} else {
+		t.votes[vi] = i
+	}
+	// the vote surrounds an earlier vote if it has an earlier source
+	if uint64(vi) < uint64(len(t.refs)) {
+		if r := t.refs[vi]; r != 0 && data.Source.Epoch < t.refData[r-1].Source.Epoch {
+			t.conflict(voteConflict{kind: SurroundVoteIncident, first: r, second: i}, vi, slot)
+		}
+	}
+}
+
+func (t *voteTracker) conflict(key voteConflict, vi common.ValidatorIndex, slot common.Slot) {
+	inc, ok := t.conflicts[key]
+	if !ok {
+		first := t.data
+		if key.kind == SurroundVoteIncident {
+			first = t.refData
+		}
+		vote1, vote2 := first[key.first-1], t.data[key.second-1]
+		inc = &Incident{Kind: key.kind, Slot: slot, Vote1: &vote1, Vote2: &vote2}
+		t.conflicts[key] = inc
+		t.order = append(t.order, key)
+	}
+	if slot > inc.Slot {
+		inc.Slot = slot
+	}
+	inc.Validators = append(inc.Validators, vi)
+}
+
+// flush returns the conflicting votes found since the last flush.
+func (t *voteTracker) flush() []Incident {
+	out := make([]Incident, 0, len(t.order))
+	for _, key := range t.order {
+		inc := t.conflicts[key]
+		sort.Slice(inc.Validators, func(i, j int) bool { return inc.Validators[i] < inc.Validators[j] })
+		out = append(out, *inc)
+	}
+	t.conflicts = make(map[voteConflict]*Incident)
+	t.order = nil
+	return out
+}
+
+// attestationDataRoot is the hash-tree-root of the attestation data, to compare votes with.
+func attestationDataRoot(data *phase0.AttestationData) common.Root {
+	return data.HashTreeRoot(tree.GetHashFn())
+}
+
+func incidentsToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB, tileType uint8, tX uint64) error {
+	var pixels [tileSize][]tilePixel
+	for x := uint64(0); x < tileSize; x++ {
+		epoch := common.Epoch(tX*tileSize + x)
+		data, err := perfDB.Get(incidentsKey(epoch), nil)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			// remove any incidents of a previous run
+			if err := tilesDB.Delete(incidentsKey(epoch), nil); err != nil {
+				return fmt.Errorf("failed to remove incidents of epoch %d: %w", epoch, err)
+			}
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get incidents of epoch %d: %w", epoch, err)
+		}
+		// copy the incidents, so the server can list them
+		if err := tilesDB.Put(incidentsKey(epoch), data, nil); err != nil {
+			return fmt.Errorf("failed to copy incidents of epoch %d: %w", epoch, err)
+		}
+		incidents, err := decodeIncidents(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode incidents of epoch %d: %w", epoch, err)
+		}
+		// red for slashed validators, orange for conflicting votes that were not slashed in the same epoch
+		slashed := make(map[common.ValidatorIndex]bool)
+		for _, inc := range incidents {
+			for _, vi := range inc.Validators {
+				slashed[vi] = slashed[vi] || inc.Kind.Slashed()
+			}
+		}
+		for vi, s := range slashed {
+			px := tilePixel{ValidatorIndex: vi, R: 0xff, G: 0x80, A: 0xff}
+			if s {
+				px.G = 0
+			}
+			pixels[x] = append(pixels[x], px)
+		}
+	}
+	return pixelsToTiles(log, tilesDB, tileType, tX, &pixels)
+}
@@ -0,0 +1,240 @@
+package fun
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/protolambda/consensus-actor/fun/beaconapi"
+)
+
+const (
+	// KeyFinalized is a 3 byte key, for an 8 byte big endian epoch value.
+	//
+	// The epoch marks where the provisional data starts: validator performance data and tiles
+	// of this epoch and later are computed from unfinalized blocks, and may change with a reorg.
+	// Stored in both the perf DB and the tiles DB. If not present, all data is final.
+	KeyFinalized string = "fin"
+)
+
+// FinalizedEpoch returns the epoch from which the data in the DB is provisional, see KeyFinalized.
+// If ok is false, all data is final.
+func FinalizedEpoch(db *leveldb.DB) (epoch common.Epoch, ok bool, err error) {
+	v, err := db.Get([]byte(KeyFinalized), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	if len(v) != 8 {
+		return 0, false, fmt.Errorf("invalid finalized epoch value: %x", v)
+	}
+	return common.Epoch(binary.BigEndian.Uint64(v)), true, nil
+}
+
+func setFinalizedEpoch(db *leveldb.DB, epoch common.Epoch) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(epoch))
+	return db.Put([]byte(KeyFinalized), v[:], nil)
+}
+
+// markFinalized marks the data before the given epoch as final, in both the perf and tiles DB.
+func markFinalized(perfDB, tilesDB *leveldb.DB, epoch common.Epoch) error {
+	if err := setFinalizedEpoch(perfDB, epoch); err != nil {
+		return fmt.Errorf("failed to mark perf data as finalized: %w", err)
+	}
+	if err := setFinalizedEpoch(tilesDB, epoch); err != nil {
+		return fmt.Errorf("failed to mark tiles as finalized: %w", err)
+	}
+	return nil
+}
+
+// rollback removes the validator performance data and tiles that may be affected by a change of the block at the given slot,
+// and recomputes them up to the given head slot. The new head is canonical to the beacon node.
+func rollback(ctx context.Context, log log.Logger, perfDB, tilesDB *leveldb.DB, spec *common.Spec,
+	api *beaconapi.Client, votes *voteTracker, resetSlot common.Slot, headSlot common.Slot) error {
+	next, err := nextPerfEpoch(perfDB)
+	if err != nil {
+		return fmt.Errorf("failed to read last perf epoch: %w", err)
+	}
+	resetEpoch := spec.SlotToEpoch(resetSlot)
+	if resetEpoch >= next {
+		// no data of the affected epochs yet
+		return updateHead(ctx, log, perfDB, tilesDB, spec, api, votes, headSlot)
+	}
+	log.Info("rolling back validator performance data and tiles", "reset_slot", resetSlot, "reset_epoch", resetEpoch)
+	if _, err := resetPerf(perfDB, spec, resetSlot, false); err != nil {
+		return fmt.Errorf("failed to reset perf data to slot %d: %w", resetSlot, err)
+	}
+	if err := resetTiles(tilesDB, spec, resetSlot); err != nil {
+		return fmt.Errorf("failed to reset tiles to slot %d: %w", resetSlot, err)
+	}
+	if _, _, err := updatePerfFromState(ctx, log, perfDB, spec, api, votes, headSlot); err != nil {
+		return fmt.Errorf("failed to update validator performance data: %w", err)
+	}
+	// the tiles of the reset epochs are recomputed, even if there is no new performance data
+	end, err := nextPerfEpoch(perfDB)
+	if err != nil {
+		return fmt.Errorf("failed to read last perf epoch: %w", err)
+	}
+	if err := UpdateTiles(log, tilesDB, perfDB, resetEpoch, end); err != nil {
+		return fmt.Errorf("failed to update tiles: %w", err)
+	}
+	return nil
+}
+
+// updateHead updates the validator performance data and tiles with the epochs completed by the given head slot.
+func updateHead(ctx context.Context, log log.Logger, perfDB, tilesDB *leveldb.DB, spec *common.Spec,
+	api *beaconapi.Client, votes *voteTracker, headSlot common.Slot) error {
+	start, end, err := updatePerfFromState(ctx, log, perfDB, spec, api, votes, headSlot)
+	if err != nil {
+		return fmt.Errorf("failed to update validator performance data: %w", err)
+	}
+	if start >= end {
+		return nil
+	}
+	if err := UpdateTiles(log, tilesDB, perfDB, start, end); err != nil {
+		return fmt.Errorf("failed to update tiles: %w", err)
+	}
+	return nil
+}
+
+// catchUp redoes the provisional data of a previous run, since the beacon node may have reorged in the meantime,
+// and then updates the validator performance data and tiles up to the finalized epoch of the beacon node.
+func catchUp(ctx context.Context, log log.Logger, perfDB, tilesDB *leveldb.DB, spec *common.Spec,
+	api *beaconapi.Client, votes *voteTracker) error {
+	if finalized, ok, err := FinalizedEpoch(perfDB); err != nil {
+		return fmt.Errorf("failed to read finalized epoch: %w", err)
+	} else if ok {
+		resetSlot, err := spec.EpochStartSlot(finalized)
+		if err != nil {
+			return fmt.Errorf("bad finalized epoch %d: %w", finalized, err)
+		}
+		log.Info("removing provisional data of previous run", "finalized", finalized)
+		if _, err := resetPerf(perfDB, spec, resetSlot, false); err != nil {
+			return fmt.Errorf("failed to reset provisional perf data: %w", err)
+		}
+		if err := resetTiles(tilesDB, spec, resetSlot); err != nil {
+			return fmt.Errorf("failed to reset provisional tiles: %w", err)
+		}
+	}
+	start, end, err := updatePerfTail(ctx, log, perfDB, spec, api, votes)
+	if err != nil {
+		return fmt.Errorf("failed to update validator performance data up to finalized epoch: %w", err)
+	}
+	// all data up to here is final, anything after is provisional
+	if err := markFinalized(perfDB, tilesDB, end); err != nil {
+		return err
+	}
+	if start < end {
+		if err := UpdateTiles(log, tilesDB, perfDB, start, end); err != nil {
+			return fmt.Errorf("failed to update tiles: %w", err)
+		}
+	}
+	return nil
+}
+
+// Live tracks the head of the beacon node, and keeps the validator performance data and tiles up to date with it,
+// until the context is canceled. Data of unfinalized epochs is marked as provisional, see KeyFinalized,
+// and is rolled back and recomputed when the beacon node reorgs.
+// When the event stream of the beacon node fails, Live reconnects after the retry duration.
+//
+// The validator performance data must be within SLOTS_PER_HISTORICAL_ROOT slots of the finalized epoch,
+// see UpdatePerfTail.
+func Live(ctx context.Context, log log.Logger, perfDB, tilesDB *leveldb.DB, spec *common.Spec,
+	api *beaconapi.Client, retry time.Duration) error {
+	for {
+		err := live(ctx, log, perfDB, tilesDB, spec, api)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Error("failed to track beacon node head, retrying", "err", err, "retry", retry)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retry):
+		}
+	}
+}
+
+func live(ctx context.Context, log log.Logger, perfDB, tilesDB *leveldb.DB, spec *common.Spec, api *beaconapi.Client) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// subscribe before catching up, to not miss any reorgs in the meantime
+	events := make(chan beaconapi.Event, 64)
+	topics := []string{beaconapi.TopicHead, beaconapi.TopicChainReorg, beaconapi.TopicFinalizedCheckpoint}
+	go func() {
+		err := api.Events(ctx, topics, func(ev *beaconapi.Event) error {
+			select {
+			case events <- *ev:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		cancel(fmt.Errorf("event stream stopped: %w", err))
+	}()
+
+	// the votes are kept between updates, to detect surround votes without requesting the earlier blocks again
+	votes := newVoteTracker(surroundVoteWindow(spec))
+	if err := catchUp(ctx, log, perfDB, tilesDB, spec, api, votes); err != nil {
+		return err
+	}
+	log.Info("tracking beacon node head")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case ev := <-events:
+			switch ev.Topic {
+			case beaconapi.TopicHead:
+				var head beaconapi.HeadEvent
+				if err := json.Unmarshal(ev.Data, &head); err != nil {
+					return fmt.Errorf("failed to decode head event: %w", err)
+				}
+				log.Debug("new head", "slot", head.Slot, "block", head.Block)
+				if err := updateHead(ctx, log, perfDB, tilesDB, spec, api, votes, head.Slot); err != nil {
+					return err
+				}
+			case beaconapi.TopicChainReorg:
+				var reorg beaconapi.ChainReorgEvent
+				if err := json.Unmarshal(ev.Data, &reorg); err != nil {
+					return fmt.Errorf("failed to decode chain reorg event: %w", err)
+				}
+				// the blocks after the common ancestor may have changed
+				ancestor := common.Slot(0)
+				if reorg.Depth < reorg.Slot {
+					ancestor = reorg.Slot - reorg.Depth
+				}
+				log.Warn("chain reorg", "slot", reorg.Slot, "depth", reorg.Depth,
+					"old_head", reorg.OldHeadBlock, "new_head", reorg.NewHeadBlock)
+				if finalized, ok, err := FinalizedEpoch(perfDB); err != nil {
+					return fmt.Errorf("failed to read finalized epoch: %w", err)
+				} else if ok && spec.SlotToEpoch(ancestor) < finalized {
+					log.Error("chain reorg of finalized data", "ancestor", ancestor, "finalized", finalized)
+				}
+				if err := rollback(ctx, log, perfDB, tilesDB, spec, api, votes, ancestor+1, reorg.Slot); err != nil {
+					return err
+				}
+			case beaconapi.TopicFinalizedCheckpoint:
+				var fin beaconapi.FinalizedCheckpointEvent
+				if err := json.Unmarshal(ev.Data, &fin); err != nil {
+					return fmt.Errorf("failed to decode finalized checkpoint event: %w", err)
+				}
+				log.Info("finalized", "epoch", fin.Epoch, "block", fin.Block)
+				if err := markFinalized(perfDB, tilesDB, fin.Epoch); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
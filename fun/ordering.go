@@ -0,0 +1,191 @@
+package fun
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// noRowValidator marks rows without a validator
+const noRowValidator = ^common.ValidatorIndex(0)
+
+// ValidatorRows are the validator performance values of an epoch in the rows of the tiles (the y axis).
+type ValidatorRows struct {
+	// Count is the number of rows.
+	Count uint64
+	// Perf returns the validator performance of the given row, and false if the row does not show a validator.
+	Perf func(row uint64) (ValidatorPerformance, bool)
+}
+
+// rowsFromOrder returns the rows of the validators in the given row order, with the given performance by validator index.
+// The row order may have gaps, with noRowValidator, to separate groups of validators.
+// Validators without performance data are not shown.
+func rowsFromOrder(order []common.ValidatorIndex, perf []ValidatorPerformance) ValidatorRows {
+	return ValidatorRows{
+		Count: uint64(len(order)),
+		Perf: func(row uint64) (ValidatorPerformance, bool) {
+			vi := order[row]
+			if vi == noRowValidator || uint64(vi) >= uint64(len(perf)) {
+				return 0, false
+			}
+			return perf[vi], true
+		},
+	}
+}
+
+// ValidatorOrdering orders the validators along the y axis of the tiles.
+type ValidatorOrdering interface {
+	// Rows returns the rows of the validators in the epoch, the epoch is keyed like KeyPerf.
+	// The given performance is the perf data of the epoch, by validator index.
+	Rows(perfDB *leveldb.DB, epoch common.Epoch, perf []ValidatorPerformance) (ValidatorRows, error)
+}
+
+// ValidatorIndexOrdering orders the validators by validator index.
+type ValidatorIndexOrdering struct{}
+
+func (ValidatorIndexOrdering) Rows(perfDB *leveldb.DB, epoch common.Epoch, perf []ValidatorPerformance) (ValidatorRows, error) {
+	return ValidatorRows{
+		Count: uint64(len(perf)),
+		Perf: func(row uint64) (ValidatorPerformance, bool) {
+			return perf[row], true
+		},
+	}, nil
+}
+
+// CommitteePositionOrdering orders the active validators by their position in the committee shuffling of the epoch:
+// by slot, committee index, and position in the committee. Validators that attest together are next to each other.
+// The rows are the committee-ordered performance of the epoch, see KeyCommitteePerf.
+type CommitteePositionOrdering struct{}
+
+func (CommitteePositionOrdering) Rows(perfDB *leveldb.DB, epoch common.Epoch, perf []ValidatorPerformance) (ValidatorRows, error) {
+	committees, err := getCommitteePerf(perfDB, epoch)
+	if err != nil {
+		return ValidatorRows{}, err
+	}
+	return ValidatorRows{
+		Count: uint64(len(committees.Perf)),
+		Perf: func(row uint64) (ValidatorPerformance, bool) {
+			return committees.Perf[row], true
+		},
+	}, nil
+}
+
+// ActivationEpochOrdering orders the active validators by activation epoch, and then by validator index.
+type ActivationEpochOrdering struct{}
+
+func (ActivationEpochOrdering) Rows(perfDB *leveldb.DB, epoch common.Epoch, perf []ValidatorPerformance) (ValidatorRows, error) {
+	active, err := getActiveValidators(perfDB, epoch)
+	if err != nil {
+		return ValidatorRows{}, err
+	}
+	order := make([]int, len(active.Indices))
+	for i := range order {
+		order[i] = i
+	}
+	// the indices are in increasing order, a stable sort keeps that order within an activation epoch
+	sort.SliceStable(order, func(i, j int) bool {
+		return active.Activations[order[i]] < active.Activations[order[j]]
+	})
+	out := make([]common.ValidatorIndex, len(order))
+	for row, i := range order {
+		out[row] = active.Indices[i]
+	}
+	return rowsFromOrder(out, perf), nil
+}
+
+// CustomOrdering orders validators by user-supplied lists of validator indices, the same for every epoch.
+// Validators that are not listed are not shown.
+type CustomOrdering struct {
+	order []common.ValidatorIndex
+}
+
+// LoadCustomOrdering loads a custom ordering from a text file, with a list of validator indices per line,
+// separated by commas or whitespace. Empty lines and lines starting with # are ignored.
+// The lists are ordered as in the file, with an empty row between the lists to separate them.
+func LoadCustomOrdering(path string) (*CustomOrdering, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open custom ordering file: %w", err)
+	}
+	defer f.Close()
+	var order []common.ValidatorIndex
+	seen := make(map[common.ValidatorIndex]struct{})
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(order) > 0 {
+			order = append(order, noRowValidator)
+		}
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		}) {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil || common.ValidatorIndex(v) == noRowValidator {
+				return nil, fmt.Errorf("invalid validator index %q on line %d", field, lineNum)
+			}
+			vi := common.ValidatorIndex(v)
+			if _, ok := seen[vi]; ok {
+				return nil, fmt.Errorf("duplicate validator index %d on line %d", vi, lineNum)
+			}
+			seen[vi] = struct{}{}
+			order = append(order, vi)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read custom ordering file: %w", err)
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("no validator indices in custom ordering file %q", path)
+	}
+	return &CustomOrdering{order: order}, nil
+}
+
+func (c *CustomOrdering) Rows(perfDB *leveldb.DB, epoch common.Epoch, perf []ValidatorPerformance) (ValidatorRows, error) {
+	return rowsFromOrder(c.order, perf), nil
+}
+
+const (
+	OrderingValidatorIndex    = "validator-index"
+	OrderingCommitteePosition = "committee-position"
+	OrderingActivationEpoch   = "activation-epoch"
+	// OrderingCustom is not registered by default, see RegisterOrdering and LoadCustomOrdering.
+	OrderingCustom = "custom"
+)
+
+var (
+	orderingsLock sync.RWMutex
+	// orderings are the registered validator orderings by name. Tile types refer to orderings by name.
+	orderings = map[string]ValidatorOrdering{
+		OrderingValidatorIndex:    ValidatorIndexOrdering{},
+		OrderingCommitteePosition: CommitteePositionOrdering{},
+		OrderingActivationEpoch:   ActivationEpochOrdering{},
+	}
+)
+
+// RegisterOrdering registers a validator ordering by name, replacing any ordering with the same name.
+// Tiles of the tile types with the ordering are computed from then on, see UpdateTiles.
+func RegisterOrdering(name string, ordering ValidatorOrdering) {
+	orderingsLock.Lock()
+	defer orderingsLock.Unlock()
+	orderings[name] = ordering
+}
+
+func getOrdering(name string) (ValidatorOrdering, bool) {
+	orderingsLock.RLock()
+	defer orderingsLock.RUnlock()
+	o, ok := orderings[name]
+	return o, ok
+}
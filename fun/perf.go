@@ -9,10 +9,8 @@ import (
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/golang/snappy"
-	"github.com/protolambda/zrnt/eth2/beacon/altair"
-	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
-	"github.com/protolambda/zrnt/eth2/beacon/capella"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/electra"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 	"github.com/protolambda/zrnt/eth2/util/hashing"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -50,13 +48,19 @@ const (
 	HeadDistance ValidatorPerformance = 0x01_00_00_00
 
 	ValidatorExists ValidatorPerformance = 0x00_00_00_01
+
+	// the ValidatorStatus of the validator during the epoch (3 bits). 0 if unknown, i.e. data from before it was tracked
+	ValidatorStatusBits ValidatorPerformance = 0x00_00_00_02
+
+	ValidatorStatusMask ValidatorPerformance = 0x00_00_00_0e
 )
 
-func shufflingSeed(spec *common.Spec, randaoFn RandaoLookup, epoch common.Epoch) ([32]byte, error) {
+// epochSeed is the spec get_seed function, with the randao mix of the epoch served by randaoFn.
+func epochSeed(randaoFn RandaoLookup, domain common.BLSDomainType, epoch common.Epoch) ([32]byte, error) {
 	buf := make([]byte, 4+8+32)
 
 	// domain type
-	copy(buf[0:4], common.DOMAIN_BEACON_ATTESTER[:])
+	copy(buf[0:4], domain[:])
 
 	// epoch
 	binary.LittleEndian.PutUint64(buf[4:4+8], uint64(epoch))
@@ -71,14 +75,16 @@ func shufflingSeed(spec *common.Spec, randaoFn RandaoLookup, epoch common.Epoch)
 }
 
 func shuffling(spec *common.Spec, randaoFn RandaoLookup, indicesBounded []common.BoundedIndex, epoch common.Epoch) (*common.ShufflingEpoch, error) {
-	seed, err := shufflingSeed(spec, randaoFn, epoch)
+	seed, err := epochSeed(randaoFn, common.DOMAIN_BEACON_ATTESTER, epoch)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compute seed: %v", err)
 	}
 	return common.NewShufflingEpoch(spec, indicesBounded, seed, epoch), nil
 }
 
-// with 1 epoch delay (inclusion can be delayed), check validator performance
+// with 1 epoch delay (inclusion can be delayed), check validator performance.
+// If votes is not nil, the votes of the attesters are tracked, to detect conflicting votes.
+// The shuffling of the processed epoch is returned with the performance, to lay it out in committee order.
 // if currEp == 0, then process only 0, filtered for target == 0
 // if currEp == 1, then process 0 and 1, filtered for target == 0
 // if currEp == 2, then process 1 and 2, filtered for target == 1
@@ -86,7 +92,7 @@ func shuffling(spec *common.Spec, randaoFn RandaoLookup, indicesBounded []common
 func processPerf(spec *common.Spec,
 	blockRootFn BlockRootLookup,
 	attFn AttestationsLookup, randaoFn RandaoLookup,
-	indicesBounded []common.BoundedIndex, currEp common.Epoch) ([]ValidatorPerformance, error) {
+	indicesBounded []common.BoundedIndex, votes *voteTracker, currEp common.Epoch) ([]ValidatorPerformance, *common.ShufflingEpoch, error) {
 	// don't have to re-hash the block if we just load the hashes
 
 	// get all block roots in previous and current epoch (or just current if genesis)
@@ -96,7 +102,7 @@ func processPerf(spec *common.Spec,
 	prevEp := currEp.Previous()
 	prevStart, err := spec.EpochStartSlot(prevEp)
 	if err != nil {
-		return nil, fmt.Errorf("bad epoch start slot of prev epoch: %w", err)
+		return nil, nil, fmt.Errorf("bad epoch start slot of prev epoch: %w", err)
 	}
 
 	count := spec.SLOTS_PER_EPOCH * 2
@@ -108,7 +114,7 @@ func processPerf(spec *common.Spec,
 		slot := prevStart + i
 		blockRoot, err := blockRootFn(slot)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get block root of slot: %d", slot)
+			return nil, nil, fmt.Errorf("failed to get block root of slot: %d", slot)
 		}
 		roots = append(roots, blockRoot)
 	}
@@ -118,7 +124,7 @@ func processPerf(spec *common.Spec,
 	for i := common.Slot(0); i < count; i++ {
 		slot := prevStart + i
 		if atts, err := attFn(slot); err != nil {
-			return nil, fmt.Errorf("failed to get block at slot %d: %v", slot, err)
+			return nil, nil, fmt.Errorf("failed to get block at slot %d: %v", slot, err)
 		} else {
 			blocks = append(blocks, SlotAttestations{Slot: slot, Attestations: atts})
 		}
@@ -126,7 +132,7 @@ func processPerf(spec *common.Spec,
 
 	prevShuf, err := shuffling(spec, randaoFn, indicesBounded, prevEp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get shuffling for epoch %d: %v", prevEp, err)
+		return nil, nil, fmt.Errorf("failed to get shuffling for epoch %d: %v", prevEp, err)
 	}
 
 	// figure out how much space we need. There may be some gaps,
@@ -142,11 +148,13 @@ func processPerf(spec *common.Spec,
 	for i := range validatorPerfs {
 		validatorPerfs[i] = ValidatorExists
 	}
-	// TODO: second perf array, in order of committees, so next stage doesn't deal with shuffling
-	///      and per slot / committee index, instead of per epoch
 
 	expectedTargetRoot := roots[0]
 
+	if votes != nil {
+		votes.start(prevEp)
+	}
+
 	// early blocks first, previous epoch (if any), then current epoch
 	for _, bl := range blocks {
 		for _, att := range bl.Attestations {
@@ -181,21 +189,125 @@ func processPerf(spec *common.Spec,
 			// inclusion distance
 			perf |= InclusionDistance * ValidatorPerformance(bl.Slot-att.Data.Slot)
 
-			comm := prevShuf.Committees[att.Data.Slot-prevStart][att.Data.Index]
-			for bitIndex, valIndex := range comm {
-				if bl := att.AggregationBits.BitLen(); bl != uint64(len(comm)) {
-					return nil, fmt.Errorf("unexpected attestation bitfield length: %d (expected %d) in epoch %d", bl, len(comm), prevEp)
+			var dataRoot common.Root
+			if votes != nil {
+				dataRoot = attestationDataRoot(&att.Data)
+			}
+
+			err := forEachAttester(spec, prevShuf, prevStart, &att, func(valIndex common.ValidatorIndex) {
+				// only if the validator was not already seen
+				if validatorPerfs[valIndex]&InclusionDistanceMask == 0 {
+					validatorPerfs[valIndex] = perf
 				}
-				if att.AggregationBits.GetBit(uint64(bitIndex)) {
-					// only if the validator was not already seen
-					if validatorPerfs[valIndex]&InclusionDistanceMask == 0 {
-						validatorPerfs[valIndex] = perf
-					}
+				if votes != nil {
+					votes.add(valIndex, &att.Data, dataRoot, bl.Slot)
 				}
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w in epoch %d", err, prevEp)
+			}
+		}
+	}
+	return validatorPerfs, prevShuf, nil
+}
+
+// forEachAttester calls fn with each validator in the aggregation bits of the attestation,
+// with the committees of the given shuffling, of the epoch that starts at the given slot.
+func forEachAttester(spec *common.Spec, shuf *common.ShufflingEpoch, start common.Slot,
+	att *electra.Attestation, fn func(valIndex common.ValidatorIndex)) error {
+	// The aggregation bits span all committees in the committee bits, in order of committee index (EIP-7549).
+	// Pre-Electra attestations are converted to this layout, with a single committee bit.
+	slotComms := shuf.Committees[att.Data.Slot-start]
+	offset := uint64(0)
+	for commIndex := uint64(0); commIndex < uint64(spec.MAX_COMMITTEES_PER_SLOT); commIndex++ {
+		if !att.CommitteeBits.GetBit(commIndex) {
+			continue
+		}
+		if commIndex >= uint64(len(slotComms)) {
+			return fmt.Errorf("attestation committee index %d out of range, slot %d has %d committees", commIndex, att.Data.Slot, len(slotComms))
+		}
+		comm := slotComms[commIndex]
+		for bitIndex, valIndex := range comm {
+			if att.AggregationBits.GetBit(offset + uint64(bitIndex)) {
+				fn(valIndex)
+			}
+		}
+		offset += uint64(len(comm))
+	}
+	if bl := att.AggregationBits.BitLen(); bl != offset {
+		return fmt.Errorf("unexpected attestation bitfield length: %d (expected %d)", bl, offset)
+	}
+	return nil
+}
+
+// processVotes tracks the votes of the attesters like processPerf, without the validator performance,
+// so no block roots are needed.
+func processVotes(spec *common.Spec, attFn AttestationsLookup, randaoFn RandaoLookup,
+	indicesBounded []common.BoundedIndex, votes *voteTracker, currEp common.Epoch) error {
+	prevEp := currEp.Previous()
+	prevStart, err := spec.EpochStartSlot(prevEp)
+	if err != nil {
+		return fmt.Errorf("bad epoch start slot of prev epoch: %w", err)
+	}
+	count := spec.SLOTS_PER_EPOCH * 2
+	if prevEp == currEp {
+		count = spec.SLOTS_PER_EPOCH
+	}
+	prevShuf, err := shuffling(spec, randaoFn, indicesBounded, prevEp)
+	if err != nil {
+		return fmt.Errorf("failed to get shuffling for epoch %d: %v", prevEp, err)
+	}
+	votes.start(prevEp)
+	for i := common.Slot(0); i < count; i++ {
+		slot := prevStart + i
+		atts, err := attFn(slot)
+		if err != nil {
+			return fmt.Errorf("failed to get block at slot %d: %v", slot, err)
+		}
+		for _, att := range atts {
+			if att.Data.Target.Epoch != prevEp {
+				continue
 			}
+			dataRoot := attestationDataRoot(&att.Data)
+			err := forEachAttester(spec, prevShuf, prevStart, &att, func(valIndex common.ValidatorIndex) {
+				votes.add(valIndex, &att.Data, dataRoot, slot)
+			})
+			if err != nil {
+				return fmt.Errorf("%w in epoch %d", err, prevEp)
+			}
+		}
+	}
+	return nil
+}
+
+// seedVotes tracks the votes of the target epochs within the surround vote window before the given start epoch,
+// unless the vote tracker already has the votes up to the epoch before,
+// so surround votes are detected in the first epochs of the range too.
+// Conflicts among the seed votes are already stored with the epochs before, and are discarded.
+func seedVotes(ctx context.Context, spec *common.Spec, attFn AttestationsLookup, randaoFn RandaoLookup,
+	indicesBounded []common.BoundedIndex, votes *voteTracker, start common.Epoch) error {
+	// processing an epoch tracks the votes of the target epoch before it
+	if start > 0 && votes.follows(start-1) {
+		return nil
+	}
+	votes.reset()
+	if start <= 1 {
+		return nil
+	}
+	first := common.Epoch(1)
+	if start > votes.window+1 {
+		first = start - votes.window
+	}
+	for currEp := first; currEp < start; currEp++ {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("stopped before processing votes of epoch %d: %w", currEp, err)
 		}
+		if err := processVotes(spec, attFn, randaoFn, indicesBounded, votes, currEp); err != nil {
+			return fmt.Errorf("failed to process votes of epoch %d: %w", currEp, err)
+		}
+		votes.flush()
 	}
-	return validatorPerfs, nil
+	return nil
 }
 
 func getPerf(perfDB *leveldb.DB, currEp common.Epoch) ([]ValidatorPerformance, error) {
@@ -228,43 +340,131 @@ func lastPerfEpoch(perfDB *leveldb.DB) (common.Epoch, error) {
 	}
 }
 
-func resetPerf(perfDB *leveldb.DB, spec *common.Spec, resetSlot common.Slot) error {
+// resetPerf removes the validator performance data of the epoch of the given slot and later.
+// It returns the number of removed perf epochs, the other per-epoch data of those epochs is removed with it.
+// With dryRun the data is counted, but not removed.
+func resetPerf(perfDB *leveldb.DB, spec *common.Spec, resetSlot common.Slot, dryRun bool) (int, error) {
 	ep, err := lastPerfEpoch(perfDB)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if ep < spec.SlotToEpoch(resetSlot) {
-		return nil
+		return 0, nil
 	}
 
-	prefix := []byte(KeyPerf)
 	start := uint64(spec.SlotToEpoch(resetSlot))
 	end := uint64(ep) + 1
 
-	keyRange := &util.Range{
-		Start: make([]byte, 3+8),
-		Limit: make([]byte, 3+8),
-	}
-	copy(keyRange.Start[:3], prefix)
-	binary.BigEndian.PutUint64(keyRange.Start[3:], start)
-	copy(keyRange.Limit[:3], prefix)
-	binary.BigEndian.PutUint64(keyRange.Limit[3:], end)
-
-	iter := perfDB.NewIterator(keyRange, nil)
-	defer iter.Release()
-
+	// the committee-ordered perf, active validators, rewards, sync committee, proposals and incidents data
+	// is keyed by the same epochs as the perf data
 	var batch leveldb.Batch
-	for iter.Next() {
-		batch.Delete(iter.Key())
+	epochs := 0
+	for _, prefix := range []string{KeyPerf, KeyCommitteePerf, KeyActiveValidators, KeyRewards, KeySyncCommittee, KeyProposals, KeyIncidents} {
+		keyRange := &util.Range{
+			Start: make([]byte, 3+8),
+			Limit: make([]byte, 3+8),
+		}
+		copy(keyRange.Start[:3], prefix)
+		binary.BigEndian.PutUint64(keyRange.Start[3:], start)
+		copy(keyRange.Limit[:3], prefix)
+		binary.BigEndian.PutUint64(keyRange.Limit[3:], end)
+
+		iter := perfDB.NewIterator(keyRange, nil)
+		for iter.Next() {
+			batch.Delete(iter.Key())
+			if prefix == KeyPerf {
+				epochs++
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return 0, fmt.Errorf("failed to iterate %q data: %w", prefix, err)
+		}
+	}
+	if dryRun {
+		return epochs, nil
 	}
 
 	if err := perfDB.Write(&batch, nil); err != nil {
-		return fmt.Errorf("failed to cleanup conflicting perf mix data with key %v", err)
+		return 0, fmt.Errorf("failed to cleanup conflicting perf mix data with key %v", err)
 	}
 
+	return epochs, nil
+}
+
+// ResetPerf removes the validator performance data of the given epoch and later,
+// e.g. to recompute it after a bad run. With dryRun the data to remove is only logged.
+// Tiles of the removed epochs are not reset, see ResetTiles.
+func ResetPerf(log log.Logger, perfDB *leveldb.DB, spec *common.Spec, fromEpoch common.Epoch, dryRun bool) error {
+	last, err := lastPerfEpoch(perfDB)
+	if err != nil {
+		return fmt.Errorf("failed to read last perf epoch: %w", err)
+	}
+	resetSlot, err := spec.EpochStartSlot(fromEpoch)
+	if err != nil {
+		return fmt.Errorf("bad reset epoch %d: %w", fromEpoch, err)
+	}
+	count, err := resetPerf(perfDB, spec, resetSlot, dryRun)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		log.Info("no validator performance data to remove", "from_epoch", fromEpoch, "last_epoch", last)
+		return nil
+	}
+	if dryRun {
+		log.Info("would remove validator performance data (dry run)", "from_epoch", fromEpoch, "last_epoch", last, "epochs", count)
+	} else {
+		log.Info("removed validator performance data", "from_epoch", fromEpoch, "last_epoch", last, "epochs", count)
+	}
 	return nil
 }
 
+// PerfRange limits the epoch range of validator performance data to update to what the era store can serve:
+// the range is limited to the consecutive eras that serve the start epoch, and gaps in the era store are warned about.
+func PerfRange(log log.Logger, spec *common.Spec, st *era.Store, start, end common.Epoch) (common.Epoch, common.Epoch, error) {
+	ranges := st.Coverage()
+	if len(ranges) == 0 {
+		return 0, 0, fmt.Errorf("no era data")
+	}
+	// find the first range of eras that has data for the start epoch, or later
+	i := 0
+	for i < len(ranges) && spec.SlotToEpoch(ranges[i].Last) <= start {
+		i++
+	}
+	if i == len(ranges) {
+		log.Info("no era data at or after start epoch", "start_epoch", start, "max_era_epoch", spec.SlotToEpoch(ranges[i-1].Last))
+		return start, start, nil
+	}
+	r := ranges[i]
+	minEpoch, maxEpoch := spec.SlotToEpoch(r.First), spec.SlotToEpoch(r.Last)
+	if minEpoch >= maxEpoch {
+		return 0, 0, fmt.Errorf("no era data, need at least two consecutive eras, got %s", r)
+	}
+
+	epochsPerEra := spec.SlotToEpoch(era.SlotsPerEra)
+	if minEpoch > start+epochsPerEra-2 {
+		start = minEpoch - epochsPerEra + 2
+		if i > 0 {
+			log.Warn("era store has gap, adjusting lower bound", "start_epoch", start, "gap_before", r)
+		} else {
+			log.Warn("adjusting lower bound", "start_epoch", start, "min_era_epoch", minEpoch)
+		}
+	}
+	if maxEpoch < end {
+		end = maxEpoch
+		if i+1 < len(ranges) {
+			log.Warn("era store has gap, adjusting upper bound", "end_epoch", end, "gap_after", r, "next", ranges[i+1])
+		} else {
+			log.Warn("adjusting upper bound", "end_epoch", end, "max_era_epoch", maxEpoch)
+		}
+	}
+	if end < start {
+		end = start
+	}
+	return start, end, nil
+}
+
 type perfJob struct {
 	start common.Epoch
 	end   common.Epoch
@@ -274,6 +474,9 @@ func UpdatePerf(ctx context.Context, log log.Logger, perf *leveldb.DB, spec *com
 	if end < start {
 		return fmt.Errorf("invalid epoch range %d - %d", start, end)
 	}
+	if workers <= 0 {
+		return fmt.Errorf("need at least 1 worker, got %d", workers)
+	}
 	epochsPerEra := common.Epoch(era.SlotsPerEra / spec.SLOTS_PER_EPOCH)
 	log.Info("starting", "start_epoch", start, "end_epoch", end, "epochs_per_era", epochsPerEra)
 
@@ -283,6 +486,7 @@ func UpdatePerf(ctx context.Context, log log.Logger, perf *leveldb.DB, spec *com
 	wg.Add(workers)
 
 	ctx, cancelCause := context.WithCancelCause(ctx)
+	defer cancelCause(nil)
 	for i := 0; i < workers; i++ {
 		go func(i int) {
 			defer wg.Done()
@@ -346,7 +550,7 @@ func updateJob(ctx context.Context, log log.Logger, perfDB *leveldb.DB, spec *co
 	log.Info("starting job", "start_epoch", start, "end_epoch", end)
 
 	if spec.SLOTS_PER_HISTORICAL_ROOT != era.SlotsPerEra {
-		return fmt.Errorf("weird spec, expected %d slots per historical root: %w")
+		return fmt.Errorf("weird spec, expected %d slots per historical root, got %d", era.SlotsPerEra, spec.SLOTS_PER_HISTORICAL_ROOT)
 	}
 	if start+era.SlotsPerEra < end {
 		return fmt.Errorf("range too large: %d ... %d: %d diff", start, end, end-start)
@@ -359,165 +563,129 @@ func updateJob(ctx context.Context, log log.Logger, perfDB *leveldb.DB, spec *co
 	}
 	currEraSlot, _ := spec.EpochStartSlot(currEraEpoch)
 
-	var currEraBlockRoots phase0.HistoricalBatchRoots
-	var prevEraBlockRoots phase0.HistoricalBatchRoots
-	var randaoMixes phase0.RandaoMixes
-
-	var indicesBounded BoundedIndices
-
-	if currEraEpoch < spec.ALTAIR_FORK_EPOCH {
-		var state phase0.BeaconState
-		if err := st.State(currEraSlot, spec.Wrap(&state)); err != nil {
-			return err
-		}
-		currEraBlockRoots = state.BlockRoots
-		randaoMixes = state.RandaoMixes
-		indicesBounded = loadIndicesFromState(state.Validators)
-	} else if currEraEpoch < spec.BELLATRIX_FORK_EPOCH {
-		var state altair.BeaconState
-		if err := st.State(currEraSlot, spec.Wrap(&state)); err != nil {
-			return err
-		}
-		currEraBlockRoots = state.BlockRoots
-		randaoMixes = state.RandaoMixes
-		indicesBounded = loadIndicesFromState(state.Validators)
-	} else if currEraEpoch < spec.CAPELLA_FORK_EPOCH {
-		var state bellatrix.BeaconState
-		if err := st.State(currEraSlot, spec.Wrap(&state)); err != nil {
-			return err
-		}
-		currEraBlockRoots = state.BlockRoots
-		randaoMixes = state.RandaoMixes
-		indicesBounded = loadIndicesFromState(state.Validators)
-	} else {
-		var state capella.BeaconState
-		if err := st.State(currEraSlot, spec.Wrap(&state)); err != nil {
-			return err
-		}
-		currEraBlockRoots = state.BlockRoots
-		randaoMixes = state.RandaoMixes
-		indicesBounded = loadIndicesFromState(state.Validators)
+	currState, err := DecodePartialState(spec, st.PartialState, currEraSlot, "block_roots", "randao_mixes", "validators",
+		"current_sync_committee", "next_sync_committee")
+	if err != nil {
+		return err
 	}
+	currEraBlockRoots := currState.BlockRoots
+	randaoMixes := currState.RandaoMixes
+	indicesBounded := loadIndicesFromState(currState.Validators)
+	balances := loadEffectiveBalancesFromState(currState.Validators)
+	lifecycles := loadLifecyclesFromState(currState.Validators)
 
+	var prevEraBlockRoots phase0.HistoricalBatchRoots
 	if currEraEpoch >= epochsPerEra {
 		prevEraEpoch := currEraEpoch - epochsPerEra
 		prevEraSlot, _ := spec.EpochStartSlot(prevEraEpoch)
 		if prevEraEpoch+2 >= start { // if the start is close to the era boundary, we'll need to load the prev era state.
-			if prevEraEpoch < spec.ALTAIR_FORK_EPOCH {
-				var state phase0.BeaconState
-				if err := st.State(prevEraSlot, spec.Wrap(&state)); err != nil {
-					return err
-				}
-				prevEraBlockRoots = state.BlockRoots
-			} else if prevEraEpoch < spec.BELLATRIX_FORK_EPOCH {
-				var state altair.BeaconState
-				if err := st.State(prevEraSlot, spec.Wrap(&state)); err != nil {
-					return err
-				}
-				prevEraBlockRoots = state.BlockRoots
-			} else if prevEraEpoch < spec.CAPELLA_FORK_EPOCH {
-				var state bellatrix.BeaconState
-				if err := st.State(prevEraSlot, spec.Wrap(&state)); err != nil {
-					return err
-				}
-				prevEraBlockRoots = state.BlockRoots
-			} else {
-				var state capella.BeaconState
-				if err := st.State(prevEraSlot, spec.Wrap(&state)); err != nil {
-					return err
-				}
-				prevEraBlockRoots = state.BlockRoots
+			prevState, err := DecodePartialState(spec, st.PartialState, prevEraSlot, "block_roots")
+			if err != nil {
+				return err
 			}
+			prevEraBlockRoots = prevState.BlockRoots
 		}
 	}
 
-	blockRootFn := BlockRootLookup(func(slot common.Slot) (common.Root, error) {
-		if slot > currEraSlot {
-			return common.Root{}, fmt.Errorf("cannot get block root of slot %d, era stops at slot %d", slot, currEraSlot)
+	blockRootFn := stateBlockRoots(currEraSlot, currEraBlockRoots, prevEraBlockRoots)
+
+	// Read the blocks of the era with a single sequential pass.
+	// Blocks of the epochs before the first epoch are processed too, to seed the vote tracker (see seedVotes).
+	// Only the last two are streamed, the others are mostly in the previous era, and are looked up individually.
+	streamFrom := currEraSlot - era.SlotsPerEra
+	if start > 1 {
+		if prevStart, _ := spec.EpochStartSlot(start - 2); prevStart > streamFrom {
+			streamFrom = prevStart
 		}
-		if slot+era.SlotsPerEra >= currEraSlot {
-			return currEraBlockRoots[slot%era.SlotsPerEra], nil
+	}
+	stream := streamEraBlocks(ctx, spec, st, currEraSlot, streamFrom, 2*spec.SLOTS_PER_EPOCH)
+	defer stream.Close()
+
+	blockFn := BlockDataLookup(func(slot common.Slot) (*BlockData, error) {
+		if slot == 0 {
+			return nil, nil
 		}
-		if prevEraBlockRoots == nil {
-			return common.Root{}, fmt.Errorf("no previous era block roots, cannot get block root of slot %d", slot)
+		if slot >= streamFrom {
+			return stream.Block(slot)
 		}
-		if slot+era.SlotsPerEra*2 >= currEraSlot {
-			return prevEraBlockRoots[slot%era.SlotsPerEra], nil
+		block, err := DecodePartialBlock(spec, st.PartialBlock, slot)
+		if errors.Is(err, era.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
 		}
-		return common.Root{}, fmt.Errorf("slot %d too old to serve", slot)
+		return block, nil
 	})
 
-	attFn := AttestationsLookup(func(slot common.Slot) (phase0.Attestations, error) {
-		if slot == 0 {
-			return nil, nil
+	randaoFn := stateRandao(spec, currEraEpoch, randaoMixes)
+	syncFn := syncCommittees(log, spec, st.PartialState, currState.Validators, knownSyncCommittees(spec, currEraSlot, currState))
+
+	return writePerf(ctx, perfDB, spec, blockRootFn, blockFn, randaoFn, syncFn, indicesBounded, balances, lifecycles, currEraEpoch,
+		newVoteTracker(surroundVoteWindow(spec)), start, end)
+}
+
+// stateBlockRoots serves the block roots of the block roots vector of the state at the given slot,
+// and optionally of the state SLOTS_PER_HISTORICAL_ROOT slots before it.
+func stateBlockRoots(stateSlot common.Slot, blockRoots, prevBlockRoots phase0.HistoricalBatchRoots) BlockRootLookup {
+	return func(slot common.Slot) (common.Root, error) {
+		if slot > stateSlot {
+			return common.Root{}, fmt.Errorf("cannot get block root of slot %d, state is at slot %d", slot, stateSlot)
 		}
-		ep := spec.SlotToEpoch(slot)
-		if ep < spec.ALTAIR_FORK_EPOCH {
-			var block phase0.SignedBeaconBlock
-			if err := st.Block(slot, spec.Wrap(&block)); errors.Is(err, era.ErrNotExist) {
-				return nil, nil
-			} else if err != nil {
-				return nil, err
-			}
-			if slot != block.Message.Slot {
-				return nil, fmt.Errorf("loaded wrong block, got slot %d, but requested %d", block.Message.Slot, slot)
-			}
-			return block.Message.Body.Attestations, nil
-		} else if ep < spec.BELLATRIX_FORK_EPOCH {
-			var block altair.SignedBeaconBlock
-			if err := st.Block(slot, spec.Wrap(&block)); errors.Is(err, era.ErrNotExist) {
-				return nil, nil
-			} else if err != nil {
-				return nil, err
-			}
-			if slot != block.Message.Slot {
-				return nil, fmt.Errorf("loaded wrong block, got slot %d, but requested %d", block.Message.Slot, slot)
-			}
-			return block.Message.Body.Attestations, nil
-		} else if ep < spec.CAPELLA_FORK_EPOCH {
-			var block bellatrix.SignedBeaconBlock
-			if err := st.Block(slot, spec.Wrap(&block)); errors.Is(err, era.ErrNotExist) {
-				return nil, nil
-			} else if err != nil {
-				return nil, err
-			}
-			if slot != block.Message.Slot {
-				return nil, fmt.Errorf("loaded wrong block, got slot %d, but requested %d", block.Message.Slot, slot)
-			}
-			return block.Message.Body.Attestations, nil
-		} else {
-			var block capella.SignedBeaconBlock
-			if err := st.Block(slot, spec.Wrap(&block)); errors.Is(err, era.ErrNotExist) {
-				return nil, nil
-			} else if err != nil {
-				return nil, err
-			}
-			if slot != block.Message.Slot {
-				return nil, fmt.Errorf("loaded wrong block, got slot %d, but requested %d", block.Message.Slot, slot)
-			}
-			return block.Message.Body.Attestations, nil
+		if slot+era.SlotsPerEra >= stateSlot {
+			return blockRoots[slot%era.SlotsPerEra], nil
 		}
-	})
+		if prevBlockRoots == nil {
+			return common.Root{}, fmt.Errorf("no previous block roots, cannot get block root of slot %d", slot)
+		}
+		if slot+era.SlotsPerEra*2 >= stateSlot {
+			return prevBlockRoots[slot%era.SlotsPerEra], nil
+		}
+		return common.Root{}, fmt.Errorf("slot %d too old to serve", slot)
+	}
+}
 
-	randaoFn := RandaoLookup(func(epoch common.Epoch) ([32]byte, error) {
-		if epoch > currEraEpoch {
-			return [32]byte{}, fmt.Errorf("epoch too high, cannot get randao mix of epoch %d from era state at epoch %d", epoch, currEraEpoch)
+// stateRandao serves the randao mixes used for shuffling, from the randao mixes of the state at the given epoch.
+func stateRandao(spec *common.Spec, stateEpoch common.Epoch, randaoMixes phase0.RandaoMixes) RandaoLookup {
+	return func(epoch common.Epoch) ([32]byte, error) {
+		if epoch > stateEpoch {
+			return [32]byte{}, fmt.Errorf("epoch too high, cannot get randao mix of epoch %d from state at epoch %d", epoch, stateEpoch)
 		}
-		if epoch+spec.EPOCHS_PER_HISTORICAL_VECTOR < currEraEpoch {
-			return [32]byte{}, fmt.Errorf("epoch too low, cannot get randao mix of epoch %d from era state at epoch %d", epoch, currEraEpoch)
+		if epoch+spec.EPOCHS_PER_HISTORICAL_VECTOR < stateEpoch {
+			return [32]byte{}, fmt.Errorf("epoch too low, cannot get randao mix of epoch %d from state at epoch %d", epoch, stateEpoch)
 		}
 		i := epoch + spec.EPOCHS_PER_HISTORICAL_VECTOR - spec.MIN_SEED_LOOKAHEAD - 1
 		return randaoMixes[i%spec.EPOCHS_PER_HISTORICAL_VECTOR], nil
-	})
+	}
+}
 
+// writePerf processes the validator performance with the lifecycle status of the validators, by validator index
+// and in committee order, the active validators, the rewards, the block proposals, the slashing incidents,
+// and the sync committee participation if syncFn is not nil, of the epochs in the given range, and writes it to the perf DB.
+// The validator lifecycles are those of the state at the given state epoch.
+// The vote tracker is seeded with the votes before the range, see seedVotes, and has the votes of the range after.
+func writePerf(ctx context.Context, perfDB *leveldb.DB, spec *common.Spec,
+	blockRootFn BlockRootLookup, blockFn BlockDataLookup, randaoFn RandaoLookup, syncFn SyncCommitteeLookup,
+	indicesBounded []common.BoundedIndex, balances EffectiveBalances, lifecycles ValidatorLifecycles, stateEpoch common.Epoch,
+	votes *voteTracker, start, end common.Epoch) error {
+	attFn := AttestationsLookup(func(slot common.Slot) (electra.Attestations, error) {
+		block, err := blockFn(slot)
+		if err != nil || block == nil {
+			return nil, err
+		}
+		return block.Attestations, nil
+	})
+	if err := seedVotes(ctx, spec, attFn, randaoFn, indicesBounded, votes, start); err != nil {
+		return err
+	}
 	for currEp := start; currEp < end; currEp++ {
 		if err := ctx.Err(); err != nil {
 			return fmt.Errorf("stopped before processing epoch %d: %w", currEp, err)
 		}
-		validatorPerfs, err := processPerf(spec, blockRootFn, attFn, randaoFn, indicesBounded, currEp)
+		validatorPerfs, shuf, err := processPerf(spec, blockRootFn, attFn, randaoFn, indicesBounded, votes, currEp)
 		if err != nil {
 			return fmt.Errorf("failed to process epoch %d: %w", currEp, err)
 		}
+		validatorPerfs = applyValidatorStatuses(spec, validatorPerfs, lifecycles, stateEpoch, currEp.Previous())
 
 		out := make([]byte, len(validatorPerfs)*4)
 		for i, v := range validatorPerfs {
@@ -533,6 +701,67 @@ func updateJob(ctx context.Context, log log.Logger, perfDB *leveldb.DB, spec *co
 		if err := perfDB.Put(outKey[:], out, nil); err != nil {
 			return fmt.Errorf("failed to store epoch performance")
 		}
+
+		copy(outKey[:3], KeyCommitteePerf)
+		if err := perfDB.Put(outKey[:], encodeCommitteePerf(committeeOrder(shuf, validatorPerfs)), nil); err != nil {
+			return fmt.Errorf("failed to store epoch committee performance: %w", err)
+		}
+
+		copy(outKey[:3], KeyActiveValidators)
+		if err := perfDB.Put(outKey[:], encodeActiveValidators(shuf.ActiveIndices, indicesBounded), nil); err != nil {
+			return fmt.Errorf("failed to store epoch active validators: %w", err)
+		}
+
+		rewards := estimateRewards(spec, validatorPerfs, indicesBounded, balances, currEp.Previous())
+		copy(outKey[:3], KeyRewards)
+		if err := perfDB.Put(outKey[:], encodeRewards(rewards), nil); err != nil {
+			return fmt.Errorf("failed to store epoch rewards: %w", err)
+		}
+
+		proposals, err := processProposals(spec, blockFn, randaoFn, indicesBounded, balances, currEp)
+		if err != nil {
+			return fmt.Errorf("failed to process proposals of epoch %d: %w", currEp, err)
+		}
+		copy(outKey[:3], KeyProposals)
+		if err := perfDB.Put(outKey[:], encodeProposals(proposals), nil); err != nil {
+			return fmt.Errorf("failed to store epoch proposals: %w", err)
+		}
+
+		incidents, err := processSlashings(spec, blockFn, currEp)
+		if err != nil {
+			return fmt.Errorf("failed to process slashings of epoch %d: %w", currEp, err)
+		}
+		incidents = append(incidents, votes.flush()...)
+		copy(outKey[:3], KeyIncidents)
+		if len(incidents) == 0 {
+			// remove the incidents of a previous run
+			if err := perfDB.Delete(outKey[:], nil); err != nil {
+				return fmt.Errorf("failed to remove epoch incidents: %w", err)
+			}
+		} else {
+			data, err := encodeIncidents(incidents)
+			if err != nil {
+				return fmt.Errorf("failed to encode incidents of epoch %d: %w", currEp, err)
+			}
+			if err := perfDB.Put(outKey[:], data, nil); err != nil {
+				return fmt.Errorf("failed to store epoch incidents: %w", err)
+			}
+		}
+
+		if syncFn == nil {
+			continue
+		}
+		syncPerf, ok, err := processSyncCommittee(spec, blockFn, syncFn, currEp)
+		if err != nil {
+			return fmt.Errorf("failed to process sync committee of epoch %d: %w", currEp, err)
+		}
+		if !ok {
+			continue
+		}
+		copy(outKey[:3], KeySyncCommittee)
+		if err := perfDB.Put(outKey[:], encodeSyncCommitteePerf(syncPerf), nil); err != nil {
+			return fmt.Errorf("failed to store epoch sync committee participation: %w", err)
+		}
 	}
 	return nil
 }
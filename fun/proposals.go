@@ -0,0 +1,225 @@
+package fun
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// KeyProposals is a:
+	// 3 byte prefix for per-epoch block proposal keying, followed by:
+	// 8 byte big-endian epoch value.
+	//
+	// The epoch key represents the epoch of the proposal slots.
+	//
+	// Values under this key are snappy block-compressed.
+	//
+	// The value is a []ProposalDuty, one per slot of the epoch, in slot order,
+	// each encoded as 4 byte little-endian proposer index, 1 byte flags, 32 byte graffiti.
+	// The flags are proposalProduced and proposalEstimated.
+	KeyProposals string = "prp"
+)
+
+const (
+	proposalProduced  = 1 << 0
+	proposalEstimated = 1 << 1
+)
+
+// ProposalDuty is the block proposal duty of a single slot.
+type ProposalDuty struct {
+	Slot common.Slot
+	// ProposerIndex is the validator that was scheduled to propose a block at the slot.
+	ProposerIndex common.ValidatorIndex
+	// Produced is true if there is a canonical block at the slot.
+	Produced bool
+	// Estimated is true if the proposer is computed with the effective balances of a later state,
+	// instead of taken from the produced block. The actual proposer may have been a different validator.
+	Estimated bool
+	// Graffiti of the produced block, zero if no block was produced.
+	Graffiti common.Root
+}
+
+const proposalDutySize = 4 + 1 + 32
+
+func encodeProposals(duties []ProposalDuty) []byte {
+	out := make([]byte, len(duties)*proposalDutySize)
+	for i, d := range duties {
+		v := out[i*proposalDutySize : (i+1)*proposalDutySize]
+		binary.LittleEndian.PutUint32(v[0:4], uint32(d.ProposerIndex))
+		if d.Produced {
+			v[4] |= proposalProduced
+		}
+		if d.Estimated {
+			v[4] |= proposalEstimated
+		}
+		copy(v[5:], d.Graffiti[:])
+	}
+	// graffiti is mostly empty or repeated, it compresses well
+	return snappy.Encode(nil, out)
+}
+
+func getProposals(perfDB *leveldb.DB, epoch common.Epoch) ([]ProposalDuty, error) {
+	var key [3 + 8]byte
+	copy(key[:3], KeyProposals)
+	binary.BigEndian.PutUint64(key[3:], uint64(epoch))
+	data, err := perfDB.Get(key[:], nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err = snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress proposals of epoch %d: %w", epoch, err)
+	}
+	if len(data)%proposalDutySize != 0 {
+		return nil, fmt.Errorf("invalid proposals data length %d of epoch %d", len(data), epoch)
+	}
+	// there is a duty for every slot of the epoch
+	out := make([]ProposalDuty, len(data)/proposalDutySize)
+	start := common.Slot(uint64(epoch) * uint64(len(out)))
+	for i := range out {
+		v := data[i*proposalDutySize : (i+1)*proposalDutySize]
+		out[i] = ProposalDuty{
+			Slot:          start + common.Slot(i),
+			ProposerIndex: common.ValidatorIndex(binary.LittleEndian.Uint32(v[0:4])),
+			Produced:      v[4]&proposalProduced != 0,
+			Estimated:     v[4]&proposalEstimated != 0,
+		}
+		copy(out[i].Graffiti[:], v[5:])
+	}
+	return out, nil
+}
+
+// computeProposerIndex is the spec compute_proposer_index function:
+// active validators are sampled in shuffled order, and accepted with a probability proportional to their effective balance.
+// Electra samples with 16 bit random values instead of single random bytes, against the increased max effective balance.
+func computeProposerIndex(spec *common.Spec, balances EffectiveBalances, active []common.ValidatorIndex,
+	seed common.Root, electra bool) (common.ValidatorIndex, error) {
+	if len(active) == 0 {
+		return 0, errors.New("no active validators available to compute proposer")
+	}
+	var buf [32 + 8]byte
+	copy(buf[0:32], seed[:])
+	total := uint64(len(active))
+	var h common.Root
+	for i := uint64(0); i < 1_000_000; i++ {
+		candidate := active[common.PermuteIndex(uint8(spec.SHUFFLE_ROUND_COUNT), common.ValidatorIndex(i%total), total, seed)]
+		if uint64(candidate) >= uint64(len(balances)) {
+			return 0, fmt.Errorf("no effective balance of validator %d", candidate)
+		}
+		effectiveBalance := balances[candidate]
+		if electra {
+			if i%16 == 0 {
+				binary.LittleEndian.PutUint64(buf[32:], i/16)
+				h = hashing.Hash(buf[:])
+			}
+			offset := (i % 16) * 2
+			randomValue := common.Gwei(binary.LittleEndian.Uint16(h[offset : offset+2]))
+			if effectiveBalance*0xffff >= spec.MAX_EFFECTIVE_BALANCE_ELECTRA*randomValue {
+				return candidate, nil
+			}
+		} else {
+			if i%32 == 0 {
+				binary.LittleEndian.PutUint64(buf[32:], i/32)
+				h = hashing.Hash(buf[:])
+			}
+			randomByte := common.Gwei(h[i%32])
+			if effectiveBalance*0xff >= spec.MAX_EFFECTIVE_BALANCE*randomByte {
+				return candidate, nil
+			}
+		}
+	}
+	return 0, errors.New("random (but balance-biased) infinite scrolling should always find a proposer")
+}
+
+// processProposals computes the proposer of each slot of the given epoch, and checks which proposers produced a block.
+//
+// The proposers are computed with the validator registry bounds and effective balances of a later state,
+// like the attester shuffling. Effective balance changes between the epoch and the state may change the selection,
+// so the proposer of a produced block is taken from the block itself,
+// and the computed proposer of a missed slot is marked as estimated.
+func processProposals(spec *common.Spec, blockFn BlockDataLookup, randaoFn RandaoLookup,
+	indicesBounded []common.BoundedIndex, balances EffectiveBalances, epoch common.Epoch) ([]ProposalDuty, error) {
+	start, err := spec.EpochStartSlot(epoch)
+	if err != nil {
+		return nil, fmt.Errorf("bad epoch start slot of epoch %d: %w", epoch, err)
+	}
+	seed, err := epochSeed(randaoFn, common.DOMAIN_BEACON_PROPOSER, epoch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute proposer seed: %w", err)
+	}
+	active := make([]common.ValidatorIndex, 0, len(indicesBounded))
+	for _, v := range indicesBounded {
+		if v.Activation <= epoch && epoch < v.Exit {
+			active = append(active, v.Index)
+		}
+	}
+	electra := epoch >= spec.ELECTRA_FORK_EPOCH
+
+	duties := make([]ProposalDuty, 0, spec.SLOTS_PER_EPOCH)
+	var buf [32 + 8]byte
+	copy(buf[:32], seed[:])
+	for slot := start; slot < start+spec.SLOTS_PER_EPOCH; slot++ {
+		binary.LittleEndian.PutUint64(buf[32:], uint64(slot))
+		proposer, err := computeProposerIndex(spec, balances, active, hashing.Hash(buf[:]), electra)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute proposer of slot %d: %w", slot, err)
+		}
+		duty := ProposalDuty{Slot: slot, ProposerIndex: proposer, Estimated: true}
+		if slot == 0 {
+			// the genesis block is not proposed, but it is there
+			duty.Produced = true
+			duty.Estimated = false
+			duties = append(duties, duty)
+			continue
+		}
+		block, err := blockFn(slot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block at slot %d: %w", slot, err)
+		}
+		if block != nil {
+			duty.ProposerIndex = block.ProposerIndex
+			duty.Produced = true
+			duty.Estimated = false
+			duty.Graffiti = block.Graffiti
+		}
+		duties = append(duties, duty)
+	}
+	return duties, nil
+}
+
+func proposalsToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB, tileType uint8, tX uint64) error {
+	var duties [tileSize][]tileDuty
+	for x := uint64(0); x < tileSize; x++ {
+		epoch := common.Epoch(tX*tileSize + x)
+		proposals, err := getProposals(perfDB, epoch)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get proposals of epoch %d: %w", epoch, err)
+		}
+		// a validator may be scheduled for multiple slots of the same epoch
+		positions := make(map[common.ValidatorIndex]int)
+		for _, p := range proposals {
+			i, ok := positions[p.ProposerIndex]
+			if !ok {
+				i = len(duties[x])
+				positions[p.ProposerIndex] = i
+				duties[x] = append(duties[x], tileDuty{ValidatorIndex: p.ProposerIndex})
+			}
+			duties[x][i].Duties++
+			if p.Produced {
+				duties[x][i].Done++
+			} else if p.Estimated {
+				duties[x][i].Estimated++
+			}
+		}
+	}
+	return dutiesToTiles(log, tilesDB, tileType, tX, &duties)
+}
@@ -0,0 +1,277 @@
+package fun
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/math"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// KeyRewards is a:
+	// 3 byte prefix for per-epoch attestation reward keying, followed by:
+	// 8 byte big-endian epoch value.
+	//
+	// The epoch key is the same as for KeyPerf: epoch e has the rewards of the attestations of epoch e-1.
+	//
+	// Values under this key are snappy block-compressed.
+	//
+	// The value is a []ValidatorReward, by validator index,
+	// each encoded as 4 byte little-endian signed reward, 4 byte little-endian max reward.
+	KeyRewards string = "rwd"
+)
+
+// ValidatorReward is the estimated attestation reward of a validator during an epoch, in Gwei.
+//
+// The estimate is based on the validator performance data, and the effective balances of a later state.
+// It does not account for slashed validators, the inactivity leak, and effective balance changes,
+// nor for the proposer and sync committee rewards.
+type ValidatorReward struct {
+	// Reward is the net attestation reward, negative for a penalty.
+	Reward int32
+	// MaxReward is the reward the validator would have had with a perfect attestation. 0 if the validator is not active.
+	MaxReward uint32
+}
+
+const validatorRewardSize = 4 + 4
+
+func encodeRewards(rewards []ValidatorReward) []byte {
+	out := make([]byte, len(rewards)*validatorRewardSize)
+	for i, r := range rewards {
+		v := out[i*validatorRewardSize : (i+1)*validatorRewardSize]
+		binary.LittleEndian.PutUint32(v[0:4], uint32(r.Reward))
+		binary.LittleEndian.PutUint32(v[4:8], r.MaxReward)
+	}
+	// rewards of validators with the same effective balance and performance are the same, compress them
+	return snappy.Encode(nil, out)
+}
+
+func getRewards(perfDB *leveldb.DB, epoch common.Epoch) ([]ValidatorReward, error) {
+	var key [3 + 8]byte
+	copy(key[:3], KeyRewards)
+	binary.BigEndian.PutUint64(key[3:], uint64(epoch))
+	data, err := perfDB.Get(key[:], nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err = snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress rewards of epoch %d: %w", epoch, err)
+	}
+	if len(data)%validatorRewardSize != 0 {
+		return nil, fmt.Errorf("invalid rewards data length %d of epoch %d", len(data), epoch)
+	}
+	out := make([]ValidatorReward, len(data)/validatorRewardSize)
+	for i := range out {
+		v := data[i*validatorRewardSize : (i+1)*validatorRewardSize]
+		out[i] = ValidatorReward{
+			Reward:    int32(binary.LittleEndian.Uint32(v[0:4])),
+			MaxReward: binary.LittleEndian.Uint32(v[4:8]),
+		}
+	}
+	return out, nil
+}
+
+// inclusionDelay returns the inclusion distance of the validator performance, or 0 if the attestation was not included.
+func (v ValidatorPerformance) inclusionDelay() common.Slot {
+	return common.Slot((v & InclusionDistanceMask) / InclusionDistance)
+}
+
+// matchingTarget returns true if the included attestation voted for the correct target.
+func (v ValidatorPerformance) matchingTarget() bool {
+	return v.inclusionDelay() != 0 && v&TargetCorrect == TargetCorrect
+}
+
+// matchingHead returns true if the included attestation voted for the correct target and head.
+func (v ValidatorPerformance) matchingHead() bool {
+	return v.matchingTarget() && v/HeadDistance == 1
+}
+
+// participationFlags returns the Altair participation flags that the validator performance of the given epoch earns,
+// following the inclusion delay rules of the fork of the epoch.
+func participationFlags(spec *common.Spec, perf ValidatorPerformance, epoch common.Epoch) altair.ParticipationFlags {
+	delay := perf.inclusionDelay()
+	if delay == 0 {
+		return 0
+	}
+	var out altair.ParticipationFlags
+	if delay <= common.Slot(math.IntegerSquareroot(uint64(spec.SLOTS_PER_EPOCH))) {
+		out |= altair.TIMELY_SOURCE_FLAG
+	}
+	// Deneb (EIP-7045) removed the inclusion delay limit of the target flag
+	if perf.matchingTarget() && (epoch >= spec.DENEB_FORK_EPOCH || delay <= spec.SLOTS_PER_EPOCH) {
+		out |= altair.TIMELY_TARGET_FLAG
+	}
+	if perf.matchingHead() && delay == spec.MIN_ATTESTATION_INCLUSION_DELAY {
+		out |= altair.TIMELY_HEAD_FLAG
+	}
+	return out
+}
+
+// estimateRewards estimates the attestation rewards of the given epoch, from the validator performance of the epoch.
+// Before Altair the source, target, head and inclusion delay rewards apply, after Altair the participation flag rewards.
+func estimateRewards(spec *common.Spec, perfs []ValidatorPerformance,
+	indicesBounded []common.BoundedIndex, balances EffectiveBalances, epoch common.Epoch) []ValidatorReward {
+	increment := spec.EFFECTIVE_BALANCE_INCREMENT
+	active := func(vi int) bool {
+		return indicesBounded[vi].Activation <= epoch && epoch < indicesBounded[vi].Exit
+	}
+	perf := func(vi int) ValidatorPerformance {
+		if vi < len(perfs) {
+			return perfs[vi]
+		}
+		return 0
+	}
+
+	totalActive := common.Gwei(0)
+	for vi := range indicesBounded {
+		if active(vi) {
+			totalActive += balances[vi]
+		}
+	}
+	if totalActive < increment {
+		totalActive = increment
+	}
+	balanceSqRoot := common.Gwei(math.IntegerSquareroot(uint64(totalActive)))
+	totalIncrements := totalActive / increment
+
+	rewards := make([]ValidatorReward, len(indicesBounded))
+	if epoch < spec.ALTAIR_FORK_EPOCH {
+		// attesting balances of the source, target and head components
+		var attesting [3]common.Gwei
+		for vi := range indicesBounded {
+			if !active(vi) {
+				continue
+			}
+			p := perf(vi)
+			for i, ok := range []bool{p.inclusionDelay() != 0, p.matchingTarget(), p.matchingHead()} {
+				if ok {
+					attesting[i] += balances[vi]
+				}
+			}
+		}
+		for vi := range indicesBounded {
+			if !active(vi) {
+				continue
+			}
+			baseReward := balances[vi] * common.Gwei(spec.BASE_REWARD_FACTOR) / balanceSqRoot / common.BASE_REWARDS_PER_EPOCH
+			maxAttesterReward := baseReward - baseReward/common.Gwei(spec.PROPOSER_REWARD_QUOTIENT)
+			p := perf(vi)
+			var reward, maxReward int64
+			for i, ok := range []bool{p.inclusionDelay() != 0, p.matchingTarget(), p.matchingHead()} {
+				componentReward := int64(baseReward * (attesting[i] / increment) / totalIncrements)
+				maxReward += componentReward
+				if ok {
+					reward += componentReward
+				} else {
+					reward -= int64(baseReward)
+				}
+			}
+			maxReward += int64(maxAttesterReward)
+			if delay := p.inclusionDelay(); delay != 0 {
+				reward += int64(maxAttesterReward / common.Gwei(delay))
+			}
+			rewards[vi] = ValidatorReward{Reward: int32(reward), MaxReward: uint32(maxReward)}
+		}
+		return rewards
+	}
+
+	weights := [3]common.Gwei{altair.TIMELY_SOURCE_WEIGHT, altair.TIMELY_TARGET_WEIGHT, altair.TIMELY_HEAD_WEIGHT}
+	flags := [3]altair.ParticipationFlags{altair.TIMELY_SOURCE_FLAG, altair.TIMELY_TARGET_FLAG, altair.TIMELY_HEAD_FLAG}
+	var participating [3]common.Gwei
+	for vi := range indicesBounded {
+		if !active(vi) {
+			continue
+		}
+		f := participationFlags(spec, perf(vi), epoch)
+		for i, flag := range flags {
+			if f&flag != 0 {
+				participating[i] += balances[vi]
+			}
+		}
+	}
+	baseRewardPerIncrement := increment * common.Gwei(spec.BASE_REWARD_FACTOR) / balanceSqRoot
+	for vi := range indicesBounded {
+		if !active(vi) {
+			continue
+		}
+		baseReward := (balances[vi] / increment) * baseRewardPerIncrement
+		f := participationFlags(spec, perf(vi), epoch)
+		var reward, maxReward int64
+		for i, flag := range flags {
+			flagReward := int64(baseReward * weights[i] * (participating[i] / increment) / (totalIncrements * altair.WEIGHT_DENOMINATOR))
+			maxReward += flagReward
+			if f&flag != 0 {
+				reward += flagReward
+			} else if flag != altair.TIMELY_HEAD_FLAG {
+				// a missed head vote is not penalized
+				reward -= int64(baseReward * weights[i] / altair.WEIGHT_DENOMINATOR)
+			}
+		}
+		rewards[vi] = ValidatorReward{Reward: int32(reward), MaxReward: uint32(maxReward)}
+	}
+	return rewards
+}
+
+func rewardsToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB, tileType uint8, tX uint64) error {
+	var epochs [tileSize][]ValidatorReward
+	maxValidators := uint64(0)
+	for x := uint64(0); x < tileSize; x++ {
+		epoch := common.Epoch(tX*tileSize + x)
+		rewards, err := getRewards(perfDB, epoch)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			// no data for this epoch
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get rewards of epoch %d: %w", epoch, err)
+		}
+		epochs[x] = rewards
+		if uint64(len(rewards)) > maxValidators {
+			maxValidators = uint64(len(rewards))
+		}
+	}
+
+	tilesY := (maxValidators + tileSize - 1) / tileSize
+	tiles := make([][]byte, tilesY)
+	for tY := uint64(0); tY < tilesY; tY++ {
+		tiles[tY] = make([]byte, 4*tileSize*tileSize)
+	}
+	for x, rewards := range epochs {
+		for vi, r := range rewards {
+			if r.MaxReward == 0 {
+				// not active, transparent pixel
+				continue
+			}
+			tile := tiles[uint64(vi)/tileSize]
+			pos := uint64(x)*tileSize + uint64(vi)%tileSize
+			// green for the max reward, yellow for no reward, red for the max penalty
+			frac := float64(r.Reward) / float64(r.MaxReward)
+			if frac > 1 {
+				frac = 1
+			} else if frac < -1 {
+				frac = -1
+			}
+			if frac >= 0 {
+				tile[pos] = uint8((1 - frac) * 0xff)
+				tile[tileSizeSquared+pos] = 0xff
+			} else {
+				tile[pos] = 0xff
+				tile[tileSizeSquared+pos] = uint8((1 + frac) * 0xff)
+			}
+			tile[tileSizeSquared*3+pos] = 0xff
+		}
+	}
+	for tY, tile := range tiles {
+		key := tileDbKey(tileType, tX, uint64(tY), 0)
+		if err := tilesDB.Put(key, snappy.Encode(nil, tile), nil); err != nil {
+			return fmt.Errorf("failed to write tile %d:%d (zoom 0): %v", tX, tY, err)
+		}
+	}
+	return nil
+}
@@ -4,18 +4,150 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"gopkg.in/yaml.v3"
 )
 
-func loadSpec(specFilePath string) (*common.Spec, error) {
+// networks are the built-in network presets, by name.
+// Each returns a fresh copy, so callers can modify the spec freely.
+var networks = map[string]func() *common.Spec{
+	"mainnet": func() *common.Spec {
+		spec := *configs.Mainnet
+		spec.CONFIG_NAME = "mainnet"
+		spec.ELECTRA_FORK_EPOCH = 364032
+		return &spec
+	},
+	"sepolia": func() *common.Spec {
+		spec := *configs.Mainnet
+		spec.CONFIG_NAME = "sepolia"
+		spec.MIN_GENESIS_ACTIVE_VALIDATOR_COUNT = 1300
+		spec.MIN_GENESIS_TIME = 1655647200
+		spec.GENESIS_DELAY = 86400
+		spec.GENESIS_FORK_VERSION = common.Version{0x90, 0x00, 0x00, 0x69}
+		spec.ALTAIR_FORK_VERSION = common.Version{0x90, 0x00, 0x00, 0x70}
+		spec.ALTAIR_FORK_EPOCH = 50
+		spec.BELLATRIX_FORK_VERSION = common.Version{0x90, 0x00, 0x00, 0x71}
+		spec.BELLATRIX_FORK_EPOCH = 100
+		spec.CAPELLA_FORK_VERSION = common.Version{0x90, 0x00, 0x00, 0x72}
+		spec.CAPELLA_FORK_EPOCH = 56832
+		spec.DENEB_FORK_VERSION = common.Version{0x90, 0x00, 0x00, 0x73}
+		spec.DENEB_FORK_EPOCH = 132608
+		spec.ELECTRA_FORK_VERSION = common.Version{0x90, 0x00, 0x00, 0x74}
+		spec.ELECTRA_FORK_EPOCH = 222464
+		spec.DEPOSIT_CHAIN_ID = 11155111
+		spec.DEPOSIT_NETWORK_ID = 11155111
+		return &spec
+	},
+	"holesky": func() *common.Spec {
+		spec := *configs.Mainnet
+		spec.CONFIG_NAME = "holesky"
+		spec.MIN_GENESIS_TIME = 1695902100
+		spec.GENESIS_DELAY = 300
+		spec.GENESIS_FORK_VERSION = common.Version{0x01, 0x01, 0x70, 0x00}
+		spec.ALTAIR_FORK_VERSION = common.Version{0x02, 0x01, 0x70, 0x00}
+		spec.ALTAIR_FORK_EPOCH = 0
+		spec.BELLATRIX_FORK_VERSION = common.Version{0x03, 0x01, 0x70, 0x00}
+		spec.BELLATRIX_FORK_EPOCH = 0
+		spec.CAPELLA_FORK_VERSION = common.Version{0x04, 0x01, 0x70, 0x00}
+		spec.CAPELLA_FORK_EPOCH = 256
+		spec.DENEB_FORK_VERSION = common.Version{0x05, 0x01, 0x70, 0x00}
+		spec.DENEB_FORK_EPOCH = 29696
+		spec.ELECTRA_FORK_VERSION = common.Version{0x06, 0x01, 0x70, 0x00}
+		spec.ELECTRA_FORK_EPOCH = 115968
+		spec.DEPOSIT_CHAIN_ID = 17000
+		spec.DEPOSIT_NETWORK_ID = 17000
+		return &spec
+	},
+	"gnosis": func() *common.Spec {
+		spec := *configs.Mainnet
+		spec.PRESET_BASE = "gnosis"
+		spec.CONFIG_NAME = "gnosis"
+		// gnosis preset deviates from mainnet
+		spec.SLOTS_PER_EPOCH = 16
+		spec.BASE_REWARD_FACTOR = 25
+		spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD = 512
+		spec.MAX_WITHDRAWALS_PER_PAYLOAD = 8
+		spec.MAX_VALIDATORS_PER_WITHDRAWALS_SWEEP = 8192
+		spec.SECONDS_PER_SLOT = 5
+		spec.MIN_GENESIS_ACTIVE_VALIDATOR_COUNT = 4096
+		spec.MIN_GENESIS_TIME = 1638968400
+		spec.GENESIS_DELAY = 6000
+		spec.GENESIS_FORK_VERSION = common.Version{0x00, 0x00, 0x00, 0x64}
+		spec.ALTAIR_FORK_VERSION = common.Version{0x01, 0x00, 0x00, 0x64}
+		spec.ALTAIR_FORK_EPOCH = 512
+		spec.BELLATRIX_FORK_VERSION = common.Version{0x02, 0x00, 0x00, 0x64}
+		spec.BELLATRIX_FORK_EPOCH = 385536
+		spec.CAPELLA_FORK_VERSION = common.Version{0x03, 0x00, 0x00, 0x64}
+		spec.CAPELLA_FORK_EPOCH = 648704
+		spec.DENEB_FORK_VERSION = common.Version{0x04, 0x00, 0x00, 0x64}
+		spec.DENEB_FORK_EPOCH = 889856
+		spec.ELECTRA_FORK_VERSION = common.Version{0x05, 0x00, 0x00, 0x64}
+		spec.ELECTRA_FORK_EPOCH = 1337856
+		spec.DEPOSIT_CHAIN_ID = 100
+		spec.DEPOSIT_NETWORK_ID = 100
+		return &spec
+	},
+	"minimal": func() *common.Spec {
+		spec := *configs.Minimal
+		spec.CONFIG_NAME = "minimal"
+		return &spec
+	},
+}
+
+// NetworkNames lists the names of the built-in network presets, sorted alphabetically.
+func NetworkNames() []string {
+	out := make([]string, 0, len(networks))
+	for name := range networks {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NetworkSpec returns a copy of the spec of a built-in network preset.
+func NetworkSpec(name string) (*common.Spec, error) {
+	fn, ok := networks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q, expected one of: %s", name, strings.Join(NetworkNames(), ", "))
+	}
+	return fn(), nil
+}
+
+// LoadSpec loads a spec config from a JSON or YAML file (by file extension).
+// The config is applied on top of the preset named by PRESET_BASE (mainnet if not specified),
+// so that a plain network config file, without preset values, is sufficient.
+func LoadSpec(specFilePath string) (*common.Spec, error) {
 	data, err := os.ReadFile(specFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read spec file: %w", err)
 	}
+	unmarshal := json.Unmarshal
+	switch strings.ToLower(filepath.Ext(specFilePath)) {
+	case ".yaml", ".yml":
+		unmarshal = yaml.Unmarshal
+	}
+	var base struct {
+		PresetBase string `json:"PRESET_BASE" yaml:"PRESET_BASE"`
+	}
+	if err := unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal spec preset base: %w", err)
+	}
 	var x common.Spec
-	if err := json.Unmarshal(data, &x); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal json spec: %w", err)
+	switch base.PresetBase {
+	case "minimal":
+		x = *configs.Minimal
+	case "gnosis":
+		x = *networks["gnosis"]()
+	default:
+		x = *configs.Mainnet
+	}
+	if err := unmarshal(data, &x); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal spec: %w", err)
 	}
 	return &x, nil
 }
@@ -0,0 +1,163 @@
+package fun
+
+import (
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+// ValidatorStatus is the lifecycle status of a validator during an epoch,
+// encoded in the ValidatorStatusMask bits of the ValidatorPerformance.
+type ValidatorStatus uint8
+
+const (
+	// StatusUnknown is the status of validator performance data that was computed before the status was tracked.
+	StatusUnknown ValidatorStatus = iota
+	// StatusPending is the status of a validator that is deposited, but not activated yet.
+	StatusPending
+	// StatusActive is the status of an active validator that is not exiting.
+	StatusActive
+	// StatusExiting is the status of an active validator with an exit epoch, from the epoch the exit may have been initiated.
+	StatusExiting
+	// StatusExited is the status of an exited validator that is not withdrawable yet.
+	StatusExited
+	// StatusSlashed is the status of a slashed validator that is not withdrawable yet, active or exited,
+	// from the epoch it was slashed.
+	StatusSlashed
+	// StatusWithdrawable is the status of a withdrawable validator that still has a balance.
+	StatusWithdrawable
+	// StatusWithdrawn is the status of a withdrawable validator that has no balance left.
+	StatusWithdrawn
+)
+
+func (s ValidatorStatus) String() string {
+	switch s {
+	case StatusUnknown:
+		return "unknown"
+	case StatusPending:
+		return "pending"
+	case StatusActive:
+		return "active"
+	case StatusExiting:
+		return "exiting"
+	case StatusExited:
+		return "exited"
+	case StatusSlashed:
+		return "slashed"
+	case StatusWithdrawable:
+		return "withdrawable"
+	case StatusWithdrawn:
+		return "withdrawn"
+	default:
+		return "invalid"
+	}
+}
+
+// Status returns the lifecycle status of the validator, encoded in the validator performance.
+func (v ValidatorPerformance) Status() ValidatorStatus {
+	return ValidatorStatus((v & ValidatorStatusMask) / ValidatorStatusBits)
+}
+
+// ValidatorLifecycle is the part of a validator registry entry that determines the lifecycle status of the validator.
+type ValidatorLifecycle struct {
+	Eligibility      common.Epoch
+	Activation       common.Epoch
+	Exit             common.Epoch
+	Withdrawable     common.Epoch
+	Slashed          bool
+	EffectiveBalance common.Gwei
+}
+
+// ValidatorLifecycles are the lifecycles of the validators, by validator index.
+type ValidatorLifecycles []ValidatorLifecycle
+
+func loadLifecyclesFromState(validators phase0.ValidatorRegistry) ValidatorLifecycles {
+	out := make(ValidatorLifecycles, len(validators))
+	for i, v := range validators {
+		out[i] = ValidatorLifecycle{
+			Eligibility:      v.ActivationEligibilityEpoch,
+			Activation:       v.ActivationEpoch,
+			Exit:             v.ExitEpoch,
+			Withdrawable:     v.WithdrawableEpoch,
+			Slashed:          v.Slashed,
+			EffectiveBalance: v.EffectiveBalance,
+		}
+	}
+	return out
+}
+
+// status returns the status of the validator during the given epoch, and false if the validator did not exist yet.
+//
+// The registry is that of the state at the given state epoch, so the slashed flag, exit epoch and balance
+// may be from after the epoch. A validator is deposited in the epoch before its activation eligibility epoch.
+// Validators that are not eligible for activation in the registry are considered to be deposited
+// in the epoch before the state, e.g. deposits below the activation balance, since the deposit epoch is not known.
+//
+// Slashing sets the withdrawable epoch EPOCHS_PER_SLASHINGS_VECTOR epochs after the slashing,
+// so a validator is slashed from that epoch on. An exit is initiated at least 1 + MAX_SEED_LOOKAHEAD epochs
+// before the exit epoch, so a validator is exiting from that epoch on: an earlier exit in the churn queue is not known.
+func (v *ValidatorLifecycle) status(spec *common.Spec, stateEpoch common.Epoch, epoch common.Epoch) (ValidatorStatus, bool) {
+	eligibility := v.Eligibility
+	if eligibility == common.FAR_FUTURE_EPOCH {
+		eligibility = stateEpoch
+	}
+	if eligibility > epoch+1 {
+		return StatusUnknown, false
+	}
+	slashed := false
+	if v.Slashed {
+		slashedEpoch := stateEpoch
+		if v.Withdrawable != common.FAR_FUTURE_EPOCH {
+			slashedEpoch = 0
+			if v.Withdrawable > spec.EPOCHS_PER_SLASHINGS_VECTOR {
+				slashedEpoch = v.Withdrawable - spec.EPOCHS_PER_SLASHINGS_VECTOR
+			}
+		}
+		slashed = epoch >= slashedEpoch
+	}
+	switch {
+	case epoch < v.Activation:
+		return StatusPending, true
+	case epoch < v.Exit:
+		if slashed {
+			return StatusSlashed, true
+		}
+		if v.Exit != common.FAR_FUTURE_EPOCH && epoch+1+spec.MAX_SEED_LOOKAHEAD >= v.Exit {
+			return StatusExiting, true
+		}
+		return StatusActive, true
+	case epoch < v.Withdrawable:
+		if slashed {
+			return StatusSlashed, true
+		}
+		return StatusExited, true
+	case v.EffectiveBalance == 0:
+		return StatusWithdrawn, true
+	default:
+		return StatusWithdrawable, true
+	}
+}
+
+// applyValidatorStatuses encodes the lifecycle status of the validators during the given epoch
+// into the validator performance, and extends it to cover all validators that existed during the epoch.
+// The lifecycles are those of the state at the given state epoch.
+// Validators that did not exist yet have no ValidatorExists bit.
+func applyValidatorStatuses(spec *common.Spec, perfs []ValidatorPerformance, lifecycles ValidatorLifecycles, stateEpoch common.Epoch, epoch common.Epoch) []ValidatorPerformance {
+	size := len(perfs)
+	for vi := len(lifecycles) - 1; vi >= size; vi-- {
+		if _, ok := lifecycles[vi].status(spec, stateEpoch, epoch); ok {
+			size = vi + 1
+			break
+		}
+	}
+	out := make([]ValidatorPerformance, size)
+	copy(out, perfs)
+	for vi := 0; vi < size && vi < len(lifecycles); vi++ {
+		status, ok := lifecycles[vi].status(spec, stateEpoch, epoch)
+		if !ok {
+			out[vi] = 0
+			continue
+		}
+		out[vi] = (out[vi] &^ ValidatorStatusMask) | ValidatorExists | ValidatorStatusBits*ValidatorPerformance(status)
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package fun
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+// blockStream serves partially decoded blocks of a single era file, read with one sequential pass.
+// Blocks must be requested in (mostly) increasing slot order:
+// a window of recent blocks is kept, to serve slots that are requested again.
+type blockStream struct {
+	// first slot served by the stream, earlier slots must be loaded otherwise
+	from common.Slot
+	// number of slots to keep before the last requested slot
+	keep common.Slot
+
+	blocks <-chan *BlockData
+	err    error
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// lowest slot still available in the window
+	low common.Slot
+	// highest slot received from the stream so far
+	last     common.Slot
+	received bool
+	finished bool
+	window   map[common.Slot]*BlockData
+}
+
+// streamEraBlocks starts reading the blocks of the era with the given state slot, starting at the from slot,
+// in the background. The stream must be closed after use.
+func streamEraBlocks(ctx context.Context, spec *common.Spec, st *era.Store, eraSlot common.Slot, from common.Slot, keep common.Slot) *blockStream {
+	ctx, cancel := context.WithCancel(ctx)
+	blocks := make(chan *BlockData, 64)
+	s := &blockStream{
+		from:   from,
+		low:    from,
+		keep:   keep,
+		blocks: blocks,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		window: make(map[common.Slot]*BlockData),
+	}
+	go func() {
+		defer close(s.done)
+		defer close(blocks)
+		sr := snappy.NewReader(nil)
+		s.err = st.Iterate(eraSlot, func(slot common.Slot, typ era.EntryType, r io.Reader) error {
+			switch typ {
+			case era.CompressedSignedBeaconBlockType:
+				if slot < from {
+					return nil
+				}
+				sr.Reset(r)
+				block, err := DecodePartialBlock(spec, func(_ common.Slot, fields *era.BlockFields) (*era.BlockInfo, error) {
+					return era.DecodePartialBlock(sr, fields)
+				}, slot)
+				if err != nil {
+					return fmt.Errorf("failed to decode block %d: %w", slot, err)
+				}
+				select {
+				case blocks <- block:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			case era.CompressedBeaconStateType:
+				// the state comes after all blocks
+				return era.ErrStop
+			default:
+				return nil
+			}
+		})
+	}()
+	return s
+}
+
+// Block returns the block at the given slot, or nil if there is no block at the slot.
+func (s *blockStream) Block(slot common.Slot) (*BlockData, error) {
+	if slot < s.low {
+		return nil, fmt.Errorf("slot %d is not available in block stream, window starts at %d", slot, s.low)
+	}
+	for !s.finished && (!s.received || s.last < slot) {
+		block, ok := <-s.blocks
+		if !ok {
+			<-s.done
+			if s.err != nil {
+				return nil, fmt.Errorf("failed to stream era blocks: %w", s.err)
+			}
+			s.finished = true
+			break
+		}
+		s.window[block.Slot] = block
+		s.last = block.Slot
+		s.received = true
+	}
+	block := s.window[slot]
+	if slot > s.keep && slot-s.keep > s.low {
+		s.low = slot - s.keep
+		for k := range s.window {
+			if k < s.low {
+				delete(s.window, k)
+			}
+		}
+	}
+	return block, nil
+}
+
+// Close stops the stream, and waits for the background reader to exit.
+func (s *blockStream) Close() {
+	s.cancel()
+	for range s.blocks {
+	}
+	<-s.done
+}
@@ -0,0 +1,113 @@
+package fun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/protolambda/consensus-actor/fun/beaconapi"
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+// nextPerfEpoch returns the first epoch after the available validator performance data, 0 if there is none.
+func nextPerfEpoch(perfDB *leveldb.DB) (common.Epoch, error) {
+	last, err := lastPerfEpoch(perfDB)
+	if err != nil {
+		return 0, err
+	}
+	if last == 0 {
+		if _, err := getPerf(perfDB, 0); errors.Is(err, leveldb.ErrNotFound) {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return last + 1, nil
+}
+
+// syncPerfAndTiles updates the validator performance data and tiles
+// from the last available performance data up to the end of the era store,
+// and then up to the finalized epoch of the beacon node, if any.
+func syncPerfAndTiles(ctx context.Context, log log.Logger, perfDB, tilesDB *leveldb.DB, spec *common.Spec,
+	st *era.Store, api *beaconapi.Client, workers int, votes *voteTracker) error {
+	next, err := nextPerfEpoch(perfDB)
+	if err != nil {
+		return fmt.Errorf("failed to read last perf epoch: %w", err)
+	}
+	_, maxSlot := st.Bounds()
+	start, end, err := PerfRange(log, spec, st, next, spec.SlotToEpoch(maxSlot))
+	if err != nil {
+		return err
+	}
+	if start < end {
+		if err := UpdatePerf(ctx, log, perfDB, spec, st, start, end, workers); err != nil {
+			return fmt.Errorf("failed to update validator performance data: %w", err)
+		}
+	} else {
+		start = next
+		end = next
+	}
+	if api != nil {
+		tailStart, tailEnd, err := updatePerfTail(ctx, log, perfDB, spec, api, votes)
+		if err != nil {
+			return fmt.Errorf("failed to update validator performance data from beacon API: %w", err)
+		}
+		if tailStart < tailEnd {
+			if start == end {
+				start = tailStart
+			}
+			end = tailEnd
+		}
+	}
+	if start >= end {
+		log.Debug("validator performance data is up to date", "end_epoch", end)
+		return nil
+	}
+	if err := UpdateTiles(log, tilesDB, perfDB, start, end); err != nil {
+		return fmt.Errorf("failed to update tiles: %w", err)
+	}
+	return nil
+}
+
+// Sync indexes new era files of the era store every interval, and then updates the validator performance data
+// and tiles, until the context is canceled. Failed updates are logged, and retried in the next interval.
+// If a beacon API client is given, the epochs that are finalized but not archived yet are updated from the beacon node.
+func Sync(ctx context.Context, log log.Logger, perfDB, tilesDB *leveldb.DB, spec *common.Spec,
+	st *era.Store, api *beaconapi.Client, workers int, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	reported := make(map[string]struct{})
+	// the votes are kept between updates from the beacon API, to not request the earlier blocks again
+	votes := newVoteTracker(surroundVoteWindow(spec))
+	for {
+		added, err := st.Update()
+		for _, slot := range added {
+			log.Info("added era file", "era", slot/era.SlotsPerEra, "path", st.Path(slot))
+		}
+		// only report new problems, the diagnostics accumulate
+		for _, d := range st.Diagnostics() {
+			if _, ok := reported[d.String()]; !ok {
+				reported[d.String()] = struct{}{}
+				log.Warn("era store problem", "kind", d.Kind, "era", d.Slot/era.SlotsPerEra, "path", d.Path, "msg", d.Msg)
+			}
+		}
+		if err != nil {
+			log.Error("failed to update era store index", "err", err)
+		} else if err := syncPerfAndTiles(ctx, log, perfDB, tilesDB, spec, st, api, workers, votes); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Error("failed to sync", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
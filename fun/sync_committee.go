@@ -0,0 +1,226 @@
+package fun
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/protolambda/consensus-actor/fun/beaconapi"
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+const (
+	// KeySyncCommittee is a:
+	// 3 byte prefix for per-epoch sync committee participation keying, followed by:
+	// 8 byte big-endian epoch value.
+	//
+	// The epoch key represents the epoch of the blocks that include the sync aggregates.
+	// Epochs before Altair have no sync committee data.
+	//
+	// Values under this key are snappy block-compressed.
+	//
+	// The value is a []SyncCommitteePerformance, ordered by validator index,
+	// each encoded as 4 byte little-endian validator index, 2 byte little-endian duties, 2 byte little-endian signed count.
+	KeySyncCommittee string = "syn"
+)
+
+// SyncCommitteePerformance is the sync committee participation of a single validator during an epoch.
+type SyncCommitteePerformance struct {
+	ValidatorIndex common.ValidatorIndex
+	// Duties is the number of sync aggregates in the blocks of the epoch that the validator was part of.
+	// A validator may have multiple positions in the sync committee, each position counts as a duty.
+	Duties uint16
+	// Signed is the number of duties where the sync aggregate included the signature of the validator.
+	Signed uint16
+}
+
+const syncCommitteePerfSize = 4 + 2 + 2
+
+func encodeSyncCommitteePerf(perf []SyncCommitteePerformance) []byte {
+	out := make([]byte, len(perf)*syncCommitteePerfSize)
+	for i, p := range perf {
+		v := out[i*syncCommitteePerfSize : (i+1)*syncCommitteePerfSize]
+		binary.LittleEndian.PutUint32(v[0:4], uint32(p.ValidatorIndex))
+		binary.LittleEndian.PutUint16(v[4:6], p.Duties)
+		binary.LittleEndian.PutUint16(v[6:8], p.Signed)
+	}
+	// compress the output, like the attestation performance
+	return snappy.Encode(nil, out)
+}
+
+func getSyncCommitteePerf(perfDB *leveldb.DB, epoch common.Epoch) ([]SyncCommitteePerformance, error) {
+	var key [3 + 8]byte
+	copy(key[:3], KeySyncCommittee)
+	binary.BigEndian.PutUint64(key[3:], uint64(epoch))
+	data, err := perfDB.Get(key[:], nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err = snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress sync committee data of epoch %d: %w", epoch, err)
+	}
+	if len(data)%syncCommitteePerfSize != 0 {
+		return nil, fmt.Errorf("invalid sync committee data length %d of epoch %d", len(data), epoch)
+	}
+	out := make([]SyncCommitteePerformance, len(data)/syncCommitteePerfSize)
+	for i := range out {
+		v := data[i*syncCommitteePerfSize : (i+1)*syncCommitteePerfSize]
+		out[i] = SyncCommitteePerformance{
+			ValidatorIndex: common.ValidatorIndex(binary.LittleEndian.Uint32(v[0:4])),
+			Duties:         binary.LittleEndian.Uint16(v[4:6]),
+			Signed:         binary.LittleEndian.Uint16(v[6:8]),
+		}
+	}
+	return out, nil
+}
+
+// processSyncCommittee counts the sync committee duties and signatures of each sync committee member,
+// in the sync aggregates of the blocks of the given epoch.
+// If ok is false there is no sync committee data for the epoch,
+// either because it is before Altair, or because the sync committee is not available.
+func processSyncCommittee(spec *common.Spec, blockFn BlockDataLookup, syncFn SyncCommitteeLookup, epoch common.Epoch) (perf []SyncCommitteePerformance, ok bool, err error) {
+	if epoch < spec.ALTAIR_FORK_EPOCH {
+		return nil, false, nil
+	}
+	start, err := spec.EpochStartSlot(epoch)
+	if err != nil {
+		return nil, false, fmt.Errorf("bad epoch start slot of epoch %d: %w", epoch, err)
+	}
+	members := make(map[common.ValidatorIndex]*SyncCommitteePerformance)
+	for slot := start; slot < start+spec.SLOTS_PER_EPOCH; slot++ {
+		if slot == 0 {
+			continue
+		}
+		block, err := blockFn(slot)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get block at slot %d: %w", slot, err)
+		}
+		if block == nil || block.SyncAggregate == nil {
+			continue
+		}
+		committee, err := syncFn(slot)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get sync committee of slot %d: %w", slot, err)
+		}
+		if committee == nil {
+			return nil, false, nil
+		}
+		bits := block.SyncAggregate.SyncCommitteeBits
+		for i, vi := range committee {
+			p, ok := members[vi]
+			if !ok {
+				p = &SyncCommitteePerformance{ValidatorIndex: vi}
+				members[vi] = p
+			}
+			p.Duties++
+			if bits.GetBit(uint64(i)) {
+				p.Signed++
+			}
+		}
+	}
+	perf = make([]SyncCommitteePerformance, 0, len(members))
+	for _, p := range members {
+		perf = append(perf, *p)
+	}
+	sort.Slice(perf, func(i, j int) bool {
+		return perf[i].ValidatorIndex < perf[j].ValidatorIndex
+	})
+	return perf, true, nil
+}
+
+// syncCommittees serves the sync committee members by the sync committee period of the slot.
+// The given known committees are used first. Other committees are loaded from the state at the start of the period,
+// which is an era state on mainnet. Sync committee pubkeys are mapped to validator indices with the given validators.
+// If the state is not available, the committee is nil, and a warning is logged.
+func syncCommittees(log log.Logger, spec *common.Spec, stateFn PartialStateLookup, validators phase0.ValidatorRegistry,
+	known map[uint64]*common.SyncCommittee) SyncCommitteeLookup {
+	var pubkeys map[common.BLSPubkey]common.ValidatorIndex
+	cache := make(map[uint64][]common.ValidatorIndex)
+	return func(slot common.Slot) ([]common.ValidatorIndex, error) {
+		period := uint64(spec.SlotToEpoch(slot) / spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD)
+		if members, ok := cache[period]; ok {
+			return members, nil
+		}
+		committee, ok := known[period]
+		if !ok {
+			// The state at the start of the period has the committee as current committee.
+			// The fork upgrade sets the first committees, if Altair does not start at a period boundary.
+			epoch := common.Epoch(period) * spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD
+			if epoch < spec.ALTAIR_FORK_EPOCH {
+				epoch = spec.ALTAIR_FORK_EPOCH
+			}
+			stateSlot, err := spec.EpochStartSlot(epoch)
+			if err != nil {
+				return nil, fmt.Errorf("bad sync committee period %d: %w", period, err)
+			}
+			state, err := DecodePartialState(spec, stateFn, stateSlot, "current_sync_committee")
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, era.ErrNotExist) || errors.Is(err, beaconapi.ErrNotFound) {
+				log.Warn("sync committee not available, skipping sync committee data", "period", period, "state_slot", stateSlot, "err", err)
+				cache[period] = nil
+				return nil, nil
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to load sync committee of period %d: %w", period, err)
+			}
+			committee = state.CurrentSyncCommittee
+			if committee == nil {
+				return nil, fmt.Errorf("state at slot %d has no sync committee", stateSlot)
+			}
+		}
+		if pubkeys == nil {
+			pubkeys = make(map[common.BLSPubkey]common.ValidatorIndex, len(validators))
+			for i, v := range validators {
+				pubkeys[v.Pubkey] = common.ValidatorIndex(i)
+			}
+		}
+		members := make([]common.ValidatorIndex, len(committee.Pubkeys))
+		for i, pub := range committee.Pubkeys {
+			vi, ok := pubkeys[pub]
+			if !ok {
+				return nil, fmt.Errorf("unknown sync committee member %d of period %d: %s", i, period, pub)
+			}
+			members[i] = vi
+		}
+		cache[period] = members
+		return members, nil
+	}
+}
+
+// knownSyncCommittees returns the sync committees of the state at the given slot by period, to use with syncCommittees.
+func knownSyncCommittees(spec *common.Spec, stateSlot common.Slot, state *StateData) map[uint64]*common.SyncCommittee {
+	known := make(map[uint64]*common.SyncCommittee)
+	period := uint64(spec.SlotToEpoch(stateSlot) / spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD)
+	if state.CurrentSyncCommittee != nil {
+		known[period] = state.CurrentSyncCommittee
+	}
+	if state.NextSyncCommittee != nil {
+		known[period+1] = state.NextSyncCommittee
+	}
+	return known
+}
+
+func syncCommitteeToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB, tileType uint8, tX uint64) error {
+	var duties [tileSize][]tileDuty
+	for x := uint64(0); x < tileSize; x++ {
+		epoch := common.Epoch(tX*tileSize + x)
+		perf, err := getSyncCommitteePerf(perfDB, epoch)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get sync committee data of epoch %d: %w", epoch, err)
+		}
+		duties[x] = make([]tileDuty, len(perf))
+		for i, p := range perf {
+			duties[x][i] = tileDuty{ValidatorIndex: p.ValidatorIndex, Duties: p.Duties, Done: p.Signed}
+		}
+	}
+	return dutiesToTiles(log, tilesDB, tileType, tX, &duties)
+}
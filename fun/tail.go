@@ -0,0 +1,116 @@
+package fun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/view"
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/protolambda/consensus-actor/fun/beaconapi"
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+// UpdatePerfTail computes the validator performance of the epochs after the available performance data,
+// up to the finalized epoch of the beacon node, to fill in the epochs that are not archived in era files yet.
+// It returns the range of updated epochs.
+//
+// The finalized state serves the block roots, randao mixes and validators,
+// so the available performance data must be within SLOTS_PER_HISTORICAL_ROOT slots of the finalized epoch.
+func UpdatePerfTail(ctx context.Context, log log.Logger, perfDB *leveldb.DB, spec *common.Spec, api *beaconapi.Client) (start, end common.Epoch, err error) {
+	return updatePerfTail(ctx, log, perfDB, spec, api, newVoteTracker(surroundVoteWindow(spec)))
+}
+
+// updatePerfTail is UpdatePerfTail with a vote tracker that is kept between updates, see writePerf.
+func updatePerfTail(ctx context.Context, log log.Logger, perfDB *leveldb.DB, spec *common.Spec, api *beaconapi.Client,
+	votes *voteTracker) (start, end common.Epoch, err error) {
+	finalized, err := api.FinalizedEpoch(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get finalized epoch: %w", err)
+	}
+	stateSlot, err := spec.EpochStartSlot(finalized)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad finalized epoch %d: %w", finalized, err)
+	}
+	return updatePerfFromState(ctx, log, perfDB, spec, api, votes, stateSlot)
+}
+
+// updatePerfFromState computes the validator performance of the epochs after the available performance data,
+// up to the epoch of the given state slot, with the blocks and state of the beacon node.
+// The blocks are requested by slot, and thus follow the canonical chain of the beacon node.
+// The vote tracker is kept between updates, so the votes before the range are only requested when it does not follow.
+// It returns the range of updated epochs.
+func updatePerfFromState(ctx context.Context, log log.Logger, perfDB *leveldb.DB, spec *common.Spec, api *beaconapi.Client,
+	votes *voteTracker, stateSlot common.Slot) (start, end common.Epoch, err error) {
+	if spec.SLOTS_PER_HISTORICAL_ROOT != era.SlotsPerEra {
+		return 0, 0, fmt.Errorf("weird spec, expected %d slots per historical root, got %d", era.SlotsPerEra, spec.SLOTS_PER_HISTORICAL_ROOT)
+	}
+	start, err = nextPerfEpoch(perfDB)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read last perf epoch: %w", err)
+	}
+	stateEpoch := spec.SlotToEpoch(stateSlot)
+	if start >= stateEpoch {
+		log.Debug("validator performance data is up to date with state", "state_slot", stateSlot)
+		return start, start, nil
+	}
+	end = stateEpoch
+	epochsPerEra := spec.SlotToEpoch(era.SlotsPerEra)
+	if start+epochsPerEra < end+2 {
+		return 0, 0, fmt.Errorf("validator performance data ends at epoch %d, too far behind state epoch %d, update from era files first", start, stateEpoch)
+	}
+	log.Info("updating validator performance from beacon API", "start_epoch", start, "end_epoch", end, "state_slot", stateSlot)
+
+	stateFn := PartialStateLookup(func(slot common.Slot, typ *view.ContainerTypeDef, fields map[string]codec.Deserializable) error {
+		return api.PartialState(ctx, slot, typ, fields)
+	})
+	state, err := DecodePartialState(spec, stateFn, stateSlot, "block_roots", "randao_mixes", "validators",
+		"current_sync_committee", "next_sync_committee")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get state at slot %d: %w", stateSlot, err)
+	}
+
+	partialBlockFn := PartialBlockLookup(func(slot common.Slot, fields *era.BlockFields) (*era.BlockInfo, error) {
+		return api.PartialBlock(ctx, slot, fields)
+	})
+	// the previous epoch of each epoch is also processed, keep the blocks around
+	blocks := make(map[common.Slot]*BlockData)
+	blockFn := BlockDataLookup(func(slot common.Slot) (*BlockData, error) {
+		if slot == 0 {
+			return nil, nil
+		}
+		if block, ok := blocks[slot]; ok {
+			return block, nil
+		}
+		for k := range blocks {
+			if k+2*spec.SLOTS_PER_EPOCH < slot {
+				delete(blocks, k)
+			}
+		}
+		block, err := DecodePartialBlock(spec, partialBlockFn, slot)
+		if errors.Is(err, beaconapi.ErrNotFound) {
+			blocks[slot] = nil
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		blocks[slot] = block
+		return block, nil
+	})
+
+	blockRootFn := stateBlockRoots(stateSlot, state.BlockRoots, nil)
+	randaoFn := stateRandao(spec, stateEpoch, state.RandaoMixes)
+	syncFn := syncCommittees(log, spec, stateFn, state.Validators, knownSyncCommittees(spec, stateSlot, state))
+	indicesBounded := loadIndicesFromState(state.Validators)
+	balances := loadEffectiveBalancesFromState(state.Validators)
+	lifecycles := loadLifecyclesFromState(state.Validators)
+	if err := writePerf(ctx, perfDB, spec, blockRootFn, blockFn, randaoFn, syncFn, indicesBounded, balances, lifecycles, stateEpoch, votes, start, end); err != nil {
+		return 0, 0, err
+	}
+	log.Info("finished updating validator performance from beacon API", "start_epoch", start, "end_epoch", end)
+	return start, end, nil
+}
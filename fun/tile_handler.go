@@ -2,6 +2,8 @@ package fun
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
@@ -176,7 +179,93 @@ func (s *ImageHandler) HandleImgRequest(tileType uint8) http.Handler {
 		}
 		// TODO: set cache policy based on coordinates
 
+		// tiles with provisional data may still change with a reorg
+		if finalized, ok, err := FinalizedEpoch(s.TilesDB); err == nil && ok {
+			tileEnd := (tileX + 1) * (tileSize << zoom)
+			if tileEnd > uint64(finalized) {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+		}
 		w.Header().Set("Content-Type", "image/png")
 		_, _ = w.Write(buf.Bytes())
 	})
 }
+
+// HandleFinalized serves the epoch from which the tiles are provisional, see KeyFinalized.
+// The epoch is null if all tiles are final.
+func (s *ImageHandler) HandleFinalized(w http.ResponseWriter, r *http.Request) {
+	var resp struct {
+		FinalizedEpoch *common.Epoch `json:"finalized_epoch"`
+	}
+	finalized, ok, err := FinalizedEpoch(s.TilesDB)
+	if err != nil {
+		s.Log.Warn("failed to read finalized epoch", "err", err)
+		w.WriteHeader(500)
+		return
+	}
+	if ok {
+		resp.FinalizedEpoch = &finalized
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		s.Log.Debug("failed to write finalized epoch", "err", err)
+	}
+}
+
+// maxIncidentsRange is the max number of epochs to list incidents of in a single request.
+const maxIncidentsRange = 1024
+
+// HandleIncidents serves the slashings and conflicting votes of a range of epochs, see KeyIncidents.
+// The start_epoch query parameter is required, the end_epoch (exclusive) defaults to the epoch after the start epoch.
+// Only epochs with incidents are listed.
+//
+// Conflicting votes are only detected among the included attestations within a window of target epochs, see voteTracker:
+// surround votes that span more target epochs are not listed, unless they were slashed on-chain.
+func (s *ImageHandler) HandleIncidents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, err := strconv.ParseUint(q.Get("start_epoch"), 10, 64)
+	if err != nil {
+		w.WriteHeader(400)
+		_, _ = w.Write([]byte(fmt.Sprintf("bad start_epoch value: %v", err)))
+		return
+	}
+	end := start + 1
+	if v := q.Get("end_epoch"); v != "" {
+		end, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte(fmt.Sprintf("bad end_epoch value: %v", err)))
+			return
+		}
+	}
+	if end < start || end-start > maxIncidentsRange {
+		w.WriteHeader(400)
+		_, _ = w.Write([]byte(fmt.Sprintf("bad epoch range %d - %d, must be at most %d epochs", start, end, maxIncidentsRange)))
+		return
+	}
+	type epochIncidents struct {
+		Epoch     common.Epoch `json:"epoch"`
+		Incidents []Incident   `json:"incidents"`
+	}
+	resp := make([]epochIncidents, 0)
+	for epoch := common.Epoch(start); epoch < common.Epoch(end); epoch++ {
+		incidents, err := GetIncidents(s.TilesDB, epoch)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			continue
+		} else if err != nil {
+			s.Log.Warn("failed to read incidents", "epoch", epoch, "err", err)
+			w.WriteHeader(500)
+			return
+		}
+		resp = append(resp, epochIncidents{Epoch: epoch, Incidents: incidents})
+	}
+	// incidents of provisional epochs may still change with a reorg
+	if finalized, ok, err := FinalizedEpoch(s.TilesDB); err == nil && ok && end > uint64(finalized) {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.Log.Debug("failed to write incidents", "err", err)
+	}
+}
@@ -32,6 +32,84 @@ const (
 	KeyTile string = "til"
 )
 
+const (
+	// TileTypeValidatorOrder is the tile type of the attestation performance, with validators ordered by index.
+	// Tile types below 0x10 are reserved for other orderings of the attestation performance, see ValidatorOrdering.
+	TileTypeValidatorOrder uint8 = 0
+	// TileTypeAttesterOrder is the tile type of the attestation performance, with validators ordered by
+	// their position in the committee shuffling of the epoch: by slot, committee index, and position in the committee.
+	TileTypeAttesterOrder uint8 = 1
+	// TileTypeActivationOrder is the tile type of the attestation performance, with validators ordered by activation epoch.
+	TileTypeActivationOrder uint8 = 2
+	// TileTypeCustomOrder is the tile type of the attestation performance, with validators ordered by the custom ordering.
+	TileTypeCustomOrder uint8 = 3
+	// TileTypeSyncCommittee is the tile type of the sync committee participation, with validators ordered by index.
+	TileTypeSyncCommittee uint8 = 0x10
+	// TileTypeProposals is the tile type of the block proposals, with validators ordered by index.
+	TileTypeProposals uint8 = 0x11
+	// TileTypeIncidents is the tile type of the slashings and conflicting votes, with validators ordered by index.
+	TileTypeIncidents uint8 = 0x12
+	// TileTypeRewards is the tile type of the estimated attestation rewards, with validators ordered by index.
+	TileTypeRewards uint8 = 0x13
+)
+
+// tileLayer computes the base tiles of a tile type, the tiles of higher zoom levels are derived from these.
+type tileLayer struct {
+	tileType uint8
+	// name is the HTTP route of the tiles
+	name string
+	// ordering is the name of the validator ordering of the tiles, empty if the tiles are ordered by validator index.
+	// The tiles are not computed if the ordering is not registered.
+	ordering  string
+	baseTiles func(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB, tileType uint8, tX uint64) error
+}
+
+// performanceLayer is a tile layer of the attestation performance, with validators in the rows of the given ordering.
+func performanceLayer(tileType uint8, name string, ordering string) tileLayer {
+	return tileLayer{
+		tileType: tileType,
+		name:     name,
+		ordering: ordering,
+		baseTiles: func(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB, tileType uint8, tX uint64) error {
+			o, ok := getOrdering(ordering)
+			if !ok {
+				return fmt.Errorf("unknown validator ordering %q", ordering)
+			}
+			return performanceToTiles(log, tilesDB, perfDB, tileType, tX, o)
+		},
+	}
+}
+
+// tileLayers are all tile types that are kept up to date with the validator performance data.
+var tileLayers = []tileLayer{
+	performanceLayer(TileTypeValidatorOrder, "validator-order", OrderingValidatorIndex),
+	performanceLayer(TileTypeAttesterOrder, "attester-order", OrderingCommitteePosition),
+	performanceLayer(TileTypeActivationOrder, "activation-order", OrderingActivationEpoch),
+	performanceLayer(TileTypeCustomOrder, "custom-order", OrderingCustom),
+	{tileType: TileTypeSyncCommittee, name: "sync-committee", baseTiles: syncCommitteeToTiles},
+	{tileType: TileTypeProposals, name: "proposals", baseTiles: proposalsToTiles},
+	{tileType: TileTypeIncidents, name: "incidents", baseTiles: incidentsToTiles},
+	{tileType: TileTypeRewards, name: "rewards", baseTiles: rewardsToTiles},
+}
+
+// TileTypes returns the names of the tile types by tile type, the name is the HTTP route of the tiles.
+func TileTypes() map[uint8]string {
+	out := make(map[uint8]string, len(tileLayers))
+	for _, layer := range tileLayers {
+		out[layer.tileType] = layer.name
+	}
+	return out
+}
+
+// active returns false if the validator ordering of the tile layer is not registered.
+func (l *tileLayer) active() bool {
+	if l.ordering == "" {
+		return true
+	}
+	_, ok := getOrdering(l.ordering)
+	return ok
+}
+
 func tileDbKey(tileType uint8, tX uint64, tY uint64, zoom uint8) []byte {
 	var key [3 + 1 + 4 + 4 + 1]byte
 	copy(key[:3], KeyTile)
@@ -42,28 +120,34 @@ func tileDbKey(tileType uint8, tX uint64, tY uint64, zoom uint8) []byte {
 	return key[:]
 }
 
-func performanceToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB, tileType uint8, tX uint64) error {
-	maxValidators := uint64(0)
-	for x := uint64(0); x < tileSize; x++ {
-		epoch := common.Epoch(tX*tileSize + x)
-		perf, err := getPerf(perfDB, epoch)
-		if err != nil {
-			// no data for this epoch
-			continue
-		}
-		if uint64(len(perf)) > maxValidators {
-			maxValidators = uint64(len(perf))
-		}
-	}
+// statusColors are the RGB colors of validators that did not participate, by validator status.
+var statusColors = [...][3]uint8{
+	// a special gray, for data without status
+	StatusUnknown: {0x20, 0x20, 0x20},
+	// dark blue
+	StatusPending: {0x10, 0x18, 0x50},
+	// the same special gray, a missed attestation
+	StatusActive: {0x20, 0x20, 0x20},
+	// purple, a missed attestation while exiting
+	StatusExiting: {0x50, 0x20, 0x60},
+	// light gray
+	StatusExited: {0x60, 0x60, 0x60},
+	// dark red
+	StatusSlashed: {0x80, 0x08, 0x08},
+	// brown
+	StatusWithdrawable: {0x60, 0x40, 0x18},
+	// very dark gray
+	StatusWithdrawn: {0x0c, 0x0c, 0x0c},
+}
 
-	tilesY := (maxValidators + tileSize - 1) / tileSize
-	// each tile is an array of 4 byte items. tileSize consecutive of those form a row, and then tileSize rows.
+// performanceToTiles computes the base tiles of the attestation performance,
+// with the validator performance of each epoch in the rows of the given ordering.
+func performanceToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB, tileType uint8, tX uint64, ordering ValidatorOrdering) error {
 	// RGBA
-	tiles := make([][]byte, tilesY)
+	// each tile is an array of 4 byte items. tileSize consecutive of those form a row, and then tileSize rows.
+	// Tiles are added as rows are needed, the rows of the orderings differ per epoch.
+	var tiles [][]byte
 	tileBytes := 4 * tileSize * tileSize
-	for tY := uint64(0); tY < tilesY; tY++ {
-		tiles[tY] = make([]byte, tileBytes)
-	}
 	for x := uint64(0); x < tileSize; x++ {
 		epoch := common.Epoch(tX*tileSize + x)
 		//fmt.Printf("processing epoch %d\n", epoch)
@@ -73,18 +157,29 @@ func performanceToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB,
 			continue
 			//return fmt.Errorf("failed to get epoch data %d: %v", epoch, err)
 		}
+		rows, err := ordering.Rows(perfDB, epoch, perf)
+		if err != nil {
+			log.Info("no validator ordering for epoch", "type", tileType, "epoch", epoch, "err", err)
+			continue
+		}
+		for tY := uint64(len(tiles)); tY < (rows.Count+tileSize-1)/tileSize; tY++ {
+			tiles = append(tiles, make([]byte, tileBytes))
+		}
 
-		// TODO: based on tileType apply a y-axis remapping on the performance
-
-		for vi, vPerf := range perf {
-			tY := uint64(vi) / tileSize
+		for row := uint64(0); row < rows.Count; row++ {
+			vPerf, ok := rows.Perf(row)
+			if !ok {
+				// not shown, transparent pixel
+				continue
+			}
+			tY := row / tileSize
 			tile := tiles[tY]
 			tileR := tile[:tileSizeSquared]
 			tileG := tile[tileSizeSquared : tileSizeSquared*2]
 			tileB := tile[tileSizeSquared*2 : tileSizeSquared*3]
 			tileA := tile[tileSizeSquared*3:]
 
-			y := uint64(vi) % tileSize
+			y := row % tileSize
 			pos := x*tileSize + y
 			// max alpha
 			tileA[pos] = 0xff
@@ -95,11 +190,12 @@ func performanceToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB,
 				tileG[pos] = 0
 				tileB[pos] = 0
 			} else {
-				// if existent, but not participating, then color it a special gray
-				if vPerf == ValidatorExists {
-					tileR[pos] = 0x20
-					tileG[pos] = 0x20
-					tileB[pos] = 0x20
+				// if existent, but not participating, then color it by the status of the validator
+				if vPerf&InclusionDistanceMask == 0 {
+					c := statusColors[vPerf.Status()]
+					tileR[pos] = c[0]
+					tileG[pos] = c[1]
+					tileB[pos] = c[2]
 				} else {
 					// higher head distance becomes darker (unknown is 0xff)
 					headDist := uint32((vPerf >> 24) & 0xff)
@@ -124,25 +220,8 @@ func performanceToTiles(log log.Logger, tilesDB *leveldb.DB, perfDB *leveldb.DB,
 				}
 			}
 		}
-		for vi := uint64(len(perf)); vi < maxValidators; vi++ {
-			tY := vi / tileSize
-			tile := tiles[tY]
-			tileR := tile[:tileSizeSquared]
-			tileG := tile[tileSizeSquared : tileSizeSquared*2]
-			tileB := tile[tileSizeSquared*2 : tileSizeSquared*3]
-			tileA := tile[tileSizeSquared*3:]
-
-			y := vi % tileSize
-			pos := x*tileSize + y
-			// transparent pixel
-			tileR[pos] = 0
-			tileG[pos] = 0
-			tileB[pos] = 0
-			tileA[pos] = 0
-		}
 	}
 	for tY, tile := range tiles {
-		// TODO more types
 		key := tileDbKey(tileType, tX, uint64(tY), 0)
 		// compress the tile image
 		tile = snappy.Encode(nil, tile)
@@ -254,6 +333,83 @@ func convTiles(tilesDB *leveldb.DB, tileType uint8, tX uint64, zoom uint8) error
 	return nil
 }
 
+// tileDuty is the number of duties of a validator in an epoch, and how many of them were done.
+// Estimated is the number of missed duties that the validator may not have had, see ProposalDuty.
+type tileDuty struct {
+	ValidatorIndex common.ValidatorIndex
+	Duties         uint16
+	Done           uint16
+	Estimated      uint16
+}
+
+// dutiesToTiles writes the base tiles of a duty layer: the pixel of each validator with duties in an epoch
+// is green for the fraction of done duties, and red for the fraction of missed duties.
+// The pixel is faded to half opacity with the fraction of estimated duties.
+func dutiesToTiles(log log.Logger, tilesDB *leveldb.DB, tileType uint8, tX uint64, duties *[tileSize][]tileDuty) error {
+	var pixels [tileSize][]tilePixel
+	for x, epochDuties := range duties {
+		for _, d := range epochDuties {
+			if d.Duties == 0 {
+				continue
+			}
+			pixels[x] = append(pixels[x], tilePixel{
+				ValidatorIndex: d.ValidatorIndex,
+				R:              uint8(uint32(d.Duties-d.Done) * 0xff / uint32(d.Duties)),
+				G:              uint8(uint32(d.Done) * 0xff / uint32(d.Duties)),
+				A:              uint8(0xff - uint32(d.Estimated)*0x80/uint32(d.Duties)),
+			})
+		}
+	}
+	return pixelsToTiles(log, tilesDB, tileType, tX, &pixels)
+}
+
+// tilePixel is the color of a validator in an epoch.
+type tilePixel struct {
+	ValidatorIndex common.ValidatorIndex
+	R, G, B, A     uint8
+}
+
+// pixelsToTiles writes the base tiles of a sparse layer, with the given pixels per epoch of the tiles.
+// Tiles are created down to the last validator with a pixel, and are transparent where there are no pixels.
+func pixelsToTiles(log log.Logger, tilesDB *leveldb.DB, tileType uint8, tX uint64, pixels *[tileSize][]tilePixel) error {
+	maxValidators := uint64(0)
+	for _, epochPixels := range pixels {
+		for _, px := range epochPixels {
+			if n := uint64(px.ValidatorIndex) + 1; n > maxValidators {
+				maxValidators = n
+			}
+		}
+	}
+	if maxValidators == 0 {
+		log.Debug("no pixels for tiles", "type", tileType, "tX", tX)
+		return nil
+	}
+
+	tilesY := (maxValidators + tileSize - 1) / tileSize
+	tiles := make([][]byte, tilesY)
+	for tY := uint64(0); tY < tilesY; tY++ {
+		tiles[tY] = make([]byte, 4*tileSize*tileSize)
+	}
+	for x, epochPixels := range pixels {
+		for _, px := range epochPixels {
+			vi := uint64(px.ValidatorIndex)
+			tile := tiles[vi/tileSize]
+			pos := uint64(x)*tileSize + vi%tileSize
+			tile[pos] = px.R
+			tile[tileSizeSquared+pos] = px.G
+			tile[tileSizeSquared*2+pos] = px.B
+			tile[tileSizeSquared*3+pos] = px.A
+		}
+	}
+	for tY, tile := range tiles {
+		key := tileDbKey(tileType, tX, uint64(tY), 0)
+		if err := tilesDB.Put(key, snappy.Encode(nil, tile), nil); err != nil {
+			return fmt.Errorf("failed to write tile %d:%d (zoom 0): %v", tX, tY, err)
+		}
+	}
+	return nil
+}
+
 func lastTileEpoch(tilesDB *leveldb.DB, tileType uint8) (common.Epoch, error) {
 	iter := tilesDB.NewIterator(util.BytesPrefix(append([]byte(KeyTile), tileType, 0)), nil)
 	defer iter.Release()
@@ -265,44 +421,122 @@ func lastTileEpoch(tilesDB *leveldb.DB, tileType uint8) (common.Epoch, error) {
 	}
 }
 
-func resetTilesTyped(tilesDB *leveldb.DB, spec *common.Spec, tileType uint8, resetSlot common.Slot) error {
+// resetTilesTyped removes the tiles of the given type that cover the epoch of the given slot and later, at every zoom level.
+// The incidents tiles also remove the incidents that were copied with them, of the epoch of the given slot and later.
+// It returns the number of removed tiles per zoom level, and the number of removed incident epochs.
+// With dryRun the tiles are counted, but not removed.
+func resetTilesTyped(tilesDB *leveldb.DB, spec *common.Spec, tileType uint8, resetSlot common.Slot, dryRun bool) ([]int, int, error) {
 	resetEpoch := spec.SlotToEpoch(resetSlot)
 
-	lastEpoch, err := lastTileEpoch(tilesDB, tileType)
-	if err != nil {
-		return err
-	}
-
-	if resetEpoch > lastEpoch { // check if there's anything to reset
-		return nil
-	}
-
 	var batch leveldb.Batch
-	for z := uint8(0); z < maxZoom; z++ {
-		start := uint32(resetEpoch >> z)
-		end := uint32(lastEpoch >> z)
+	incidents := 0
+	if tileType == TileTypeIncidents {
 		r := &util.Range{
-			Start: make([]byte, 3+1+1+4),
-			Limit: make([]byte, 3+1+1+4),
+			Start: incidentsKey(resetEpoch),
+			Limit: util.BytesPrefix([]byte(KeyIncidents)).Limit,
 		}
-		copy(r.Start[:3], KeyTile)
-		r.Start[3] = tileType
-		r.Start[3+1] = z
-		binary.BigEndian.PutUint32(r.Start[3+1+1:], start)
-
-		copy(r.Limit[:3], KeyTile)
-		r.Limit[3] = tileType
-		r.Limit[3+1] = z
-		binary.BigEndian.PutUint32(r.Limit[3+1+1:], end+1)
-
 		iter := tilesDB.NewIterator(r, nil)
 		for iter.Next() {
 			batch.Delete(iter.Key())
+			incidents++
 		}
 		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, 0, fmt.Errorf("failed to iterate incidents: %w", err)
+		}
+	}
+
+	lastEpoch, err := lastTileEpoch(tilesDB, tileType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	counts := make([]int, maxZoom+1)
+	// the last tile starts at lastEpoch, and covers tileSize epochs
+	if resetEpoch < lastEpoch+tileSize { // check if there's any tile to reset
+		for z := uint8(0); z <= maxZoom; z++ {
+			// tile X coordinates are in units of tileSize epochs, halving with each zoom level
+			start := uint32((uint64(resetEpoch) / tileSize) >> z)
+			end := uint32((uint64(lastEpoch) / tileSize) >> z)
+			r := &util.Range{
+				Start: make([]byte, 3+1+1+4),
+				Limit: make([]byte, 3+1+1+4),
+			}
+			copy(r.Start[:3], KeyTile)
+			r.Start[3] = tileType
+			r.Start[3+1] = z
+			binary.BigEndian.PutUint32(r.Start[3+1+1:], start)
+
+			copy(r.Limit[:3], KeyTile)
+			r.Limit[3] = tileType
+			r.Limit[3+1] = z
+			binary.BigEndian.PutUint32(r.Limit[3+1+1:], end+1)
+
+			iter := tilesDB.NewIterator(r, nil)
+			for iter.Next() {
+				batch.Delete(iter.Key())
+				counts[z]++
+			}
+			iter.Release()
+			if err := iter.Error(); err != nil {
+				return nil, 0, fmt.Errorf("failed to iterate tiles of type %d at zoom %d: %w", tileType, z, err)
+			}
+		}
+	}
+	if dryRun || batch.Len() == 0 {
+		return counts, incidents, nil
 	}
 	if err := tilesDB.Write(&batch, nil); err != nil {
-		return fmt.Errorf("failed to remove tile data of type %d, resetting to slot %d: %v", tileType, resetSlot, err)
+		return nil, 0, fmt.Errorf("failed to remove tile data of type %d, resetting to slot %d: %v", tileType, resetSlot, err)
+	}
+	return counts, incidents, nil
+}
+
+// resetTiles removes the tiles of all tile layers that cover the epoch of the given slot and later.
+func resetTiles(tilesDB *leveldb.DB, spec *common.Spec, resetSlot common.Slot) error {
+	for _, layer := range tileLayers {
+		if _, _, err := resetTilesTyped(tilesDB, spec, layer.tileType, resetSlot, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetTiles removes the tiles of the given type that cover the given epoch and later, at every zoom level,
+// e.g. to recompute them after a bad run. Tiles cover tileSize epochs at zoom 0, and twice as many with each zoom level,
+// so the recomputed range starts at the first removed tile, see UpdateTiles.
+// Resetting the incidents tiles also removes the incidents served with them, of the given epoch and later.
+// With dryRun the tiles to remove are only logged.
+func ResetTiles(log log.Logger, tilesDB *leveldb.DB, spec *common.Spec, tileType uint8, fromEpoch common.Epoch, dryRun bool) error {
+	resetSlot, err := spec.EpochStartSlot(fromEpoch)
+	if err != nil {
+		return fmt.Errorf("bad reset epoch %d: %w", fromEpoch, err)
+	}
+	counts, incidents, err := resetTilesTyped(tilesDB, spec, tileType, resetSlot, dryRun)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for z, count := range counts {
+		if count == 0 {
+			continue
+		}
+		total += count
+		tileEpochs := uint64(tileSize) << z
+		firstEpoch := (uint64(fromEpoch) / tileEpochs) * tileEpochs
+		log.Info("tiles to remove", "type", tileType, "zoom", z, "tiles", count, "first_epoch", firstEpoch)
+	}
+	if incidents > 0 {
+		log.Info("incidents to remove", "type", tileType, "epochs", incidents, "from_epoch", fromEpoch)
+	}
+	if total == 0 && incidents == 0 {
+		log.Info("no tiles to remove", "type", tileType, "from_epoch", fromEpoch)
+		return nil
+	}
+	if dryRun {
+		log.Info("would remove tiles (dry run)", "type", tileType, "from_epoch", fromEpoch, "tiles", total)
+	} else {
+		log.Info("removed tiles", "type", tileType, "from_epoch", fromEpoch, "tiles", total)
 	}
 	return nil
 }
@@ -320,21 +554,27 @@ func UpdateTiles(log log.Logger, tiles, perf *leveldb.DB, startEpoch, endEpoch c
 		endEpoch = lastPerfEpoch
 	}
 
-	for tX := uint64(startEpoch) / tileSize; tX <= uint64(endEpoch)/tileSize; tX++ {
-		log.Info("creating base tiles", "tX", tX, "zoom", 0)
-		if err := performanceToTiles(log, tiles, perf, 0, tX); err != nil {
-			return fmt.Errorf("failed to update zoom 0 tiles at tX %d: %v", tX, err)
+	for _, layer := range tileLayers {
+		if !layer.active() {
+			log.Debug("skipping tiles, validator ordering is not registered", "type", layer.tileType, "ordering", layer.ordering)
+			continue
+		}
+		for tX := uint64(startEpoch) / tileSize; tX <= uint64(endEpoch)/tileSize; tX++ {
+			log.Info("creating base tiles", "type", layer.tileType, "tX", tX, "zoom", 0)
+			if err := layer.baseTiles(log, tiles, perf, layer.tileType, tX); err != nil {
+				return fmt.Errorf("failed to update zoom 0 tiles of type %d at tX %d: %v", layer.tileType, tX, err)
+			}
 		}
-	}
 
-	for z := uint8(1); z <= maxZoom; z++ {
-		tileSizeAbs := uint64(tileSize) << z
-		tilesXStart := uint64(startEpoch) / tileSizeAbs
-		tilesXEnd := (uint64(endEpoch) + tileSizeAbs - 1) / tileSizeAbs
-		for i := tilesXStart; i < tilesXEnd; i++ {
-			log.Info("computing conv tiles", "tX", i, "zoom", z)
-			if err := convTiles(tiles, 0, i, z); err != nil {
-				return fmt.Errorf("failed tile convolution layer at zoom %d tX %d: %v", z, i, err)
+		for z := uint8(1); z <= maxZoom; z++ {
+			tileSizeAbs := uint64(tileSize) << z
+			tilesXStart := uint64(startEpoch) / tileSizeAbs
+			tilesXEnd := (uint64(endEpoch) + tileSizeAbs - 1) / tileSizeAbs
+			for i := tilesXStart; i < tilesXEnd; i++ {
+				log.Info("computing conv tiles", "type", layer.tileType, "tX", i, "zoom", z)
+				if err := convTiles(tiles, layer.tileType, i, z); err != nil {
+					return fmt.Errorf("failed tile convolution layer of type %d at zoom %d tX %d: %v", layer.tileType, z, i, err)
+				}
 			}
 		}
 	}
@@ -2,24 +2,90 @@ package fun
 
 import (
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/electra"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/consensus-actor/fun/era"
 )
 
 type BoundedIndices []common.BoundedIndex
 
+// EffectiveBalances are the effective balances of the validators, by validator index.
+type EffectiveBalances []common.Gwei
+
 type RandaoLookup func(epoch common.Epoch) ([32]byte, error)
 
 type BlockRootLookup func(slot common.Slot) (common.Root, error)
 
-type AttestationsLookup func(slot common.Slot) (phase0.Attestations, error)
+// AttestationsLookup returns the attestations included in the block at the given slot,
+// in the Electra (EIP-7549) layout: pre-Electra attestations are converted with toElectraAttestations.
+type AttestationsLookup func(slot common.Slot) (electra.Attestations, error)
+
+// BlockDataLookup returns the partially decoded block at the given slot, or nil if there is no block at the slot.
+type BlockDataLookup func(slot common.Slot) (*BlockData, error)
+
+// SyncCommitteeLookup returns the validator indices of the sync committee members, by committee position,
+// of the sync committee that signs the sync aggregate of the block at the given slot.
+// The committee is nil if it is not available.
+type SyncCommitteeLookup func(slot common.Slot) ([]common.ValidatorIndex, error)
+
+type BlockLookup func(slot common.Slot, dest common.SSZObj) error
 
-type BlockLookup func(slot uint64, dest common.SSZObj) error
+// PartialBlockLookup decodes the start of the block at the given slot, and the given block body fields.
+type PartialBlockLookup func(slot common.Slot, fields *era.BlockFields) (*era.BlockInfo, error)
 
-type StateLookup func(slot uint64, dest common.SSZObj) error
+// PartialStateLookup decodes only the given fields of the state at the given slot.
+// The state type is the fork-specific beacon state type.
+type PartialStateLookup func(slot common.Slot, typ *view.ContainerTypeDef, fields map[string]codec.Deserializable) error
 
 type SlotAttestations struct {
 	Slot         common.Slot
-	Attestations phase0.Attestations
+	Attestations electra.Attestations
+}
+
+// toElectraAttestations converts pre-Electra attestations to the Electra layout:
+// the committee index moves from the attestation data to the committee bits,
+// and the aggregation bits cover just that single committee.
+func toElectraAttestations(spec *common.Spec, atts phase0.Attestations) electra.Attestations {
+	out := make(electra.Attestations, len(atts))
+	for i := range atts {
+		att := &atts[i]
+		commBits := make(electra.CommitteeBits, (uint64(spec.MAX_COMMITTEES_PER_SLOT)+7)/8)
+		commBits.SetBit(uint64(att.Data.Index), true)
+		data := att.Data
+		data.Index = 0
+		out[i] = electra.Attestation{
+			AggregationBits: electra.AttestationBits(att.AggregationBits),
+			Data:            data,
+			Signature:       att.Signature,
+			CommitteeBits:   commBits,
+		}
+	}
+	return out
+}
+
+// toElectraAttesterSlashings converts pre-Electra attester slashings to the Electra layout,
+// which only differs in the limit of the attesting indices.
+func toElectraAttesterSlashings(slashings phase0.AttesterSlashings) electra.AttesterSlashings {
+	out := make(electra.AttesterSlashings, len(slashings))
+	for i := range slashings {
+		s := &slashings[i]
+		out[i] = electra.AttesterSlashing{
+			Attestation1: electra.IndexedAttestation{
+				AttestingIndices: common.SlotCommitteeIndices(s.Attestation1.AttestingIndices),
+				Data:             s.Attestation1.Data,
+				Signature:        s.Attestation1.Signature,
+			},
+			Attestation2: electra.IndexedAttestation{
+				AttestingIndices: common.SlotCommitteeIndices(s.Attestation2.AttestingIndices),
+				Data:             s.Attestation2.Data,
+				Signature:        s.Attestation2.Signature,
+			},
+		}
+	}
+	return out
 }
 
 func loadIndicesFromState(validators phase0.ValidatorRegistry) BoundedIndices {
@@ -33,3 +99,11 @@ func loadIndicesFromState(validators phase0.ValidatorRegistry) BoundedIndices {
 	}
 	return indices
 }
+
+func loadEffectiveBalancesFromState(validators phase0.ValidatorRegistry) EffectiveBalances {
+	balances := make(EffectiveBalances, len(validators))
+	for i, v := range validators {
+		balances[i] = v.EffectiveBalance
+	}
+	return balances
+}
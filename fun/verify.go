@@ -0,0 +1,197 @@
+package fun
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+
+	"github.com/protolambda/consensus-actor/fun/era"
+)
+
+// EraIssue is a single inconsistency found in an era file.
+type EraIssue struct {
+	// Offset of the file entry with the issue, -1 if not specific to an entry.
+	Offset int64
+	Slot   common.Slot
+	Msg    string
+}
+
+func (iss EraIssue) String() string {
+	if iss.Offset < 0 {
+		return fmt.Sprintf("slot %d: %s", iss.Slot, iss.Msg)
+	}
+	return fmt.Sprintf("slot %d (offset %d): %s", iss.Slot, iss.Offset, iss.Msg)
+}
+
+// EraReport is the verification result of a single era file.
+type EraReport struct {
+	Path      string
+	Era       uint64
+	StateSlot common.Slot
+	// Root is the era root, as used in the file name, computed from the era-state.
+	Root common.Root
+	// Accumulator is the historical accumulator of the era-state.
+	Accumulator []common.Root
+	Issues      []EraIssue
+}
+
+func (r *EraReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *EraReport) issue(offset int64, slot common.Slot, format string, args ...any) {
+	r.Issues = append(r.Issues, EraIssue{Offset: offset, Slot: slot, Msg: fmt.Sprintf(format, args...)})
+}
+
+// VerifyEra checks the era file with the era-state at the given slot for internal consistency:
+// the file name, the block slots and roots against the state block roots,
+// and the era root against the historical accumulator of the state.
+// An error is returned only if the file cannot be checked at all.
+func VerifyEra(spec *common.Spec, st *era.Store, stateSlot common.Slot) (*EraReport, error) {
+	path, blockOffsets, stateOffset, err := st.Offsets(stateSlot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read era %d offsets: %w", stateSlot, err)
+	}
+	eraNumber := uint64(stateSlot / era.SlotsPerEra)
+	report := &EraReport{Path: path, Era: eraNumber, StateSlot: stateSlot}
+
+	state, err := DecodePartialState(spec, st.PartialState, stateSlot,
+		"slot", "genesis_validators_root", "block_roots", "state_roots", "historical_roots", "historical_summaries")
+	if err != nil {
+		report.issue(stateOffset, stateSlot, "failed to decode state: %v", err)
+		return report, nil
+	}
+	if state.Slot != stateSlot {
+		report.issue(stateOffset, stateSlot, "state has slot %d, but is indexed at slot %d", state.Slot, stateSlot)
+	}
+	report.Root = era.EraRoot(spec, eraNumber, state.GenesisValidatorsRoot, state.BlockRoots, state.StateRoots)
+	report.Accumulator = state.HistoricalAccumulator
+
+	if _, fileEra, shortRoot, err := era.ParseFilename(filepath.Base(path)); err != nil {
+		report.issue(-1, stateSlot, "%v", err)
+	} else {
+		if fileEra != eraNumber {
+			report.issue(-1, stateSlot, "file name has era %d, but state is of era %d", fileEra, eraNumber)
+		}
+		if !bytes.Equal(report.Root[:4], shortRoot[:]) {
+			report.issue(-1, stateSlot, "file name has short root %x, but era root is %s", shortRoot, report.Root)
+		}
+	}
+
+	if eraNumber > 0 {
+		if uint64(len(state.HistoricalAccumulator)) < eraNumber {
+			report.issue(stateOffset, stateSlot, "historical accumulator has %d entries, expected at least %d",
+				len(state.HistoricalAccumulator), eraNumber)
+		} else if acc := state.HistoricalAccumulator[eraNumber-1]; acc != report.Root {
+			report.issue(stateOffset, stateSlot, "historical accumulator entry %s does not match era root %s", acc, report.Root)
+		}
+	}
+
+	startSlot := stateSlot - common.Slot(len(blockOffsets))
+	for i, offset := range blockOffsets {
+		slot := startSlot + common.Slot(i)
+		expectedRoot := state.BlockRoots[slot%era.SlotsPerEra]
+		if offset == 0 {
+			// without block, the block root is repeated from the previous slot
+			if i > 0 && expectedRoot != state.BlockRoots[(slot-1)%era.SlotsPerEra] {
+				report.issue(-1, slot, "missing block, expected block root %s", expectedRoot)
+			}
+			continue
+		}
+		f := ForkAt(spec, spec.SlotToEpoch(slot))
+		blockSlot, blockRoot, err := f.BlockRoot(spec, st.Block, slot)
+		if err != nil {
+			report.issue(offset, slot, "failed to decode %s block: %v", f.Name, err)
+			continue
+		}
+		if blockSlot != slot {
+			report.issue(offset, slot, "block has slot %d, but is indexed at slot %d", blockSlot, slot)
+		}
+		if blockRoot != expectedRoot {
+			report.issue(offset, slot, "block root %s does not match state block root %s", blockRoot, expectedRoot)
+		}
+	}
+	return report, nil
+}
+
+// crossCheckEras checks the era roots of the reports against the historical accumulators of later eras.
+func crossCheckEras(reports []*EraReport) {
+	roots := make(map[uint64]common.Root, len(reports))
+	for _, r := range reports {
+		if r.Accumulator != nil {
+			roots[r.Era] = r.Root
+		}
+	}
+	for _, r := range reports {
+		for i, acc := range r.Accumulator {
+			prevEra := uint64(i) + 1
+			if prevEra >= r.Era {
+				break
+			}
+			if root, ok := roots[prevEra]; ok && root != acc {
+				r.issue(-1, r.StateSlot, "historical accumulator entry %s does not match root %s of era %d", acc, root, prevEra)
+			}
+		}
+	}
+}
+
+// VerifyEras verifies all era files of the store, using the given number of workers.
+// The reports are sorted by era, and cross-checked against each other.
+func VerifyEras(ctx context.Context, log log.Logger, spec *common.Spec, st *era.Store, workers int) ([]*EraReport, error) {
+	slots := st.Slots()
+
+	work := make(chan common.Slot)
+	reports := make([]*EraReport, 0, len(slots))
+	var reportsLock sync.Mutex
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	defer cancelCause(nil)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for slot := range work {
+				report, err := VerifyEra(spec, st, slot)
+				if err != nil {
+					cancelCause(fmt.Errorf("worker %d failed to verify era at slot %d: %w", i, slot, err))
+					return
+				}
+				if report.OK() {
+					log.Info("verified era file", "era", report.Era, "path", report.Path)
+				} else {
+					log.Warn("era file has issues", "era", report.Era, "path", report.Path, "issues", len(report.Issues))
+				}
+				reportsLock.Lock()
+				reports = append(reports, report)
+				reportsLock.Unlock()
+			}
+		}(i)
+	}
+
+schedule:
+	for _, slot := range slots {
+		select {
+		case work <- slot:
+		case <-ctx.Done():
+			break schedule
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Era < reports[j].Era })
+	crossCheckEras(reports)
+	return reports, nil
+}
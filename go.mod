@@ -1,14 +1,15 @@
 module github.com/protolambda/consensus-actor
 
-go 1.18
+go 1.21
 
 require (
 	github.com/ethereum/go-ethereum v1.11.6
 	github.com/golang/snappy v0.0.5-0.20220116011046-fa5810519dcb
-	github.com/protolambda/zrnt v0.30.0
+	github.com/protolambda/zrnt v0.34.1
 	github.com/protolambda/ztyp v0.2.2
 	github.com/syndtr/goleveldb v1.0.1-0.20210819022825-2ae1ddf74ef7
 	github.com/urfave/cli/v2 v2.17.2-0.20221006022127-8f469abc00aa
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -18,9 +19,8 @@ require (
 	github.com/kilic/bls12-381 v0.1.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.1.0 // indirect
 	github.com/minio/sha256-simd v1.0.0 // indirect
-	github.com/protolambda/bls12-381-util v0.0.0-20220416220906-d8552aa452c7 // indirect
+	github.com/protolambda/bls12-381-util v0.1.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/sys v0.6.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/sys v0.17.0 // indirect
 )
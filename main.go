@@ -22,6 +22,9 @@ func main() {
 		cmd.PerfCmd,
 		cmd.ServerCmd,
 		cmd.TilesCmd,
+		cmd.EraCmd,
+		cmd.SyncCmd,
+		cmd.LiveCmd,
 	}
 	err := app.Run(os.Args)
 	if err != nil {